@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"community-robot-lib/config"
+	"community-robot-lib/framework"
+	liboptions "community-robot-lib/options"
+	"community-robot-lib/utils"
+)
+
+// envPrefix is the prefix of the env vars which the flags are bound to,
+// eg ROBOT_GATEWAY_CONFIG_FILE sets --config-file.
+const envPrefix = "ROBOT_GATEWAY_"
+
+// version is set at build time by -ldflags "-X main.version=...".
+var version = "dev"
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, out io.Writer) int
+}
+
+var commands = []command{
+	{"serve", "Run the gateway, it is the default command.", runServe},
+	{"validate-config", "Check that the config is valid.", runValidateConfig},
+	{"lint", "Report the errors and warnings of the config.", runLint},
+	{"route-explain", "Explain which plugins an event of org/repo is delivered to.", runRouteExplain},
+	{"replay", "Deliver an event saved as JSON to the plugins or to an endpoint.", runReplay},
+	{"config-schema", "Print the JSON Schema of the config.", runConfigSchema},
+	{"version", "Print the version.", runVersion},
+}
+
+// runCommand runs the subcommand named by the first arg and returns the exit code.
+// The gateway is served if there is no subcommand.
+func runCommand(args []string, out io.Writer) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args, out)
+	}
+
+	for i := range commands {
+		if commands[i].name == args[0] {
+			return commands[i].run(args[1:], out)
+		}
+	}
+
+	if args[0] != "help" {
+		fmt.Fprintf(out, "unknown command: %s\n\n", args[0])
+	}
+	printUsage(out)
+
+	if args[0] == "help" {
+		return 0
+	}
+
+	return 2
+}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintf(out, "Usage: %s <command> [flags]\n\nCommands:\n", botName)
+	for i := range commands {
+		fmt.Fprintf(out, "  %-16s %s\n", commands[i].name, commands[i].usage)
+	}
+	fmt.Fprintf(out, "\nEvery flag can also be set by the env var %s<FLAG_NAME> or in the file of --%s.\n",
+		envPrefix, liboptions.FlagsFileFlag)
+}
+
+func newFlagSet(name string, out io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+
+	return fs
+}
+
+// writeCounter counts what is written, to tell whether fs reported the error.
+type writeCounter struct {
+	io.Writer
+	n int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.n += len(p)
+
+	return w.Writer.Write(p)
+}
+
+// parseFlags parses args into fs by liboptions.ParseFlags and writes the error
+// to out. The errors of the command line are written by fs already, while the
+// ones of the env vars and the flags file are not.
+func parseFlags(fs *flag.FlagSet, args []string, out io.Writer) error {
+	w := &writeCounter{Writer: out}
+	fs.SetOutput(w)
+	defer fs.SetOutput(out)
+
+	err := liboptions.ParseFlags(fs, args, envPrefix)
+	if err != nil && w.n == 0 {
+		fmt.Fprintf(out, "error: %v\n", err)
+	}
+
+	return err
+}
+
+// configFlags are the flags of the commands which read the config offline.
+type configFlags struct {
+	path          string
+	unknownFields string
+}
+
+func (o *configFlags) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.path, "config-file", "", "Path to config file, or to a directory or a glob of config files, or a url of http(s).")
+	fs.StringVar(&o.unknownFields, "config-unknown-fields", config.UnknownFieldsReject, "How the unknown fields of config are handled: reject or warn.")
+}
+
+// load parses the config without validating it.
+func (o *configFlags) load() (*configuration, error) {
+	if o.path == "" {
+		return nil, fmt.Errorf("missing config-file")
+	}
+
+	c := &configuration{}
+	if err := config.Parse(config.NewConfigSource(o.path), c, o.unknownFields); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func runValidateConfig(args []string, out io.Writer) int {
+	var o configFlags
+
+	fs := newFlagSet("validate-config", out)
+	o.AddFlags(fs)
+	if err := parseFlags(fs, args, out); err != nil {
+		return 2
+	}
+
+	c, err := o.load()
+	if err == nil {
+		err = c.Validate()
+	}
+
+	if err != nil {
+		fmt.Fprintf(out, "invalid config: %v\n", err)
+
+		return 1
+	}
+
+	fmt.Fprintln(out, "config is valid")
+
+	return 0
+}
+
+func runRouteExplain(args []string, out io.Writer) int {
+	var o configFlags
+	var org, repo, event string
+
+	fs := newFlagSet("route-explain", out)
+	o.AddFlags(fs)
+	fs.StringVar(&org, "org", "", "Org of the event.")
+	fs.StringVar(&repo, "repo", "", "Repo of the event.")
+	fs.StringVar(&event, "event", "", "Name of the event, such as Merge Request Hook. The name of a schedule is given by schedule:<name>.")
+	if err := parseFlags(fs, args, out); err != nil {
+		return 2
+	}
+
+	if org == "" || event == "" {
+		fmt.Fprintln(out, "error: org and event are required")
+
+		return 2
+	}
+
+	c, err := o.load()
+	if err != nil {
+		fmt.Fprintf(out, "error: %v\n", err)
+
+		return 1
+	}
+
+	explainRoute(c, org, repo, event, out)
+
+	return 0
+}
+
+// explainRoute writes which entries of repo_plugins org/repo matches, and
+// whether each plugin of them receives the event and why.
+func explainRoute(c *configuration, org, repo, event string, out io.Writer) {
+	schedule, isSchedule := strings.CutPrefix(event, scheduleEventName+":")
+
+	for _, scope := range []string{org, org + "/" + repo} {
+		names, ok := c.ConfigItems.RepoPlugins[scope]
+		if !ok {
+			fmt.Fprintf(out, "repo_plugins[%s]: no entry\n", scope)
+		} else {
+			fmt.Fprintf(out, "repo_plugins[%s]: %s\n", scope, strings.Join(names, ", "))
+		}
+	}
+
+	names := c.pluginNames(org, repo)
+	if len(names) == 0 {
+		fmt.Fprintln(out, "the event is delivered to no plugin")
+
+		return
+	}
+
+	dynamic := c.dynamicPluginSet()
+
+	for _, name := range names {
+		if dynamic.Has(name) {
+			fmt.Fprintf(out, "plugin %s: dynamic, it receives the event if its registration subscribes to it\n", name)
+
+			continue
+		}
+
+		var p *pluginConfig
+		for i := range c.ConfigItems.Plugins {
+			if c.ConfigItems.Plugins[i].Name == name {
+				p = &c.ConfigItems.Plugins[i]
+
+				break
+			}
+		}
+
+		if p == nil {
+			fmt.Fprintf(out, "plugin %s: not defined in plugins\n", name)
+
+			continue
+		}
+
+		var matched bool
+		if isSchedule {
+			for i := range p.Schedules {
+				matched = matched || p.Schedules[i].Name == schedule
+			}
+		} else {
+			matched = len(matchPlugin(&[]pluginConfig{*p}, event, name)) > 0
+		}
+
+		if !matched {
+			if isSchedule {
+				fmt.Fprintf(out, "plugin %s: skipped, it has no schedule %s\n", name, schedule)
+			} else {
+				fmt.Fprintf(out, "plugin %s: skipped, it subscribes to [%s]\n", name, strings.Join(p.Events, ", "))
+			}
+
+			continue
+		}
+
+		fmt.Fprintf(out, "plugin %s: delivered to %s", name, p.Endpoint)
+		if p.Ordered {
+			fmt.Fprint(out, ", ordered")
+		}
+		if p.Debounce.applies(event) {
+			fmt.Fprintf(out, ", debounced within %s by %s", p.Debounce.Window, strings.Join(p.Debounce.keyFields(), ","))
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+func runReplay(args []string, out io.Writer) int {
+	var o configFlags
+	var eventFile, endpoint, format string
+	var dryRun bool
+
+	fs := newFlagSet("replay", out)
+	o.AddFlags(fs)
+	fs.StringVar(&eventFile, "event-file", "", "Path to the event saved as JSON.")
+	fs.StringVar(&endpoint, "endpoint", "", "Endpoint to deliver the event to, instead of the plugins routed by config-file.")
+	fs.StringVar(&format, "event-format", framework.EventFormatGob, "How the event is encoded: gob, or proto for the plugins built with a lib of the versioned schema.")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only print where the event would be delivered.")
+	if err := parseFlags(fs, args, out); err != nil {
+		return 2
+	}
+
+	if eventFile == "" {
+		fmt.Fprintln(out, "error: missing event-file")
+
+		return 2
+	}
+
+	b, err := os.ReadFile(eventFile)
+	if err != nil {
+		fmt.Fprintf(out, "error: %v\n", err)
+
+		return 1
+	}
+
+	evt := new(framework.GenericEvent)
+	if err := json.Unmarshal(b, evt); err != nil {
+		fmt.Fprintf(out, "error: parsing event: %v\n", err)
+
+		return 1
+	}
+
+	endpoints := []string{endpoint}
+	if endpoint == "" {
+		c, err := o.load()
+		if err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+
+			return 1
+		}
+
+		if evt.PlatformName == schedulePlatform && evt.EventName == scheduleEventName {
+			endpoints = nil
+			for _, p := range c.GetScheduledPlugins(evt.Org, evt.Repo, evt.Action) {
+				endpoints = append(endpoints, p.Endpoint)
+			}
+		} else {
+			endpoints = c.GetEndpoints(evt.Org, evt.Repo, evt.EventName)
+		}
+	}
+
+	if len(endpoints) == 0 {
+		fmt.Fprintln(out, "the event is delivered to no plugin")
+
+		return 0
+	}
+
+	hc := utils.NewHttpClient(3)
+	failed := false
+
+	for _, e := range endpoints {
+		if dryRun {
+			fmt.Fprintf(out, "%s: skipped, dry run\n", e)
+
+			continue
+		}
+
+		req, err := newDeliveryRequest(e, evt, format)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", e, err)
+			failed = true
+
+			continue
+		}
+
+		resp, err := hc.DoSend(req)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", e, err)
+			failed = true
+
+			continue
+		}
+		_ = resp.Body.Close()
+
+		fmt.Fprintf(out, "%s: %s\n", e, resp.Status)
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+func runConfigSchema(args []string, out io.Writer) int {
+	if err := newFlagSet("config-schema", out).Parse(args); err != nil {
+		return 2
+	}
+
+	b, err := config.GenerateSchema(&configuration{})
+	if err != nil {
+		fmt.Fprintf(out, "error: %v\n", err)
+
+		return 1
+	}
+
+	fmt.Fprintln(out, string(b))
+
+	return 0
+}
+
+func runVersion(args []string, out io.Writer) int {
+	if err := newFlagSet("version", out).Parse(args); err != nil {
+		return 2
+	}
+
+	fmt.Fprintf(out, "%s %s\n", botName, version)
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return 0
+	}
+
+	fmt.Fprintf(out, "go: %s\n", info.GoVersion)
+
+	settings := map[string]string{}
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+
+	for _, k := range []string{"vcs.revision", "vcs.time", "vcs.modified"} {
+		if v, ok := settings[k]; ok {
+			fmt.Fprintf(out, "%s: %s\n", k, v)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	liboptions "community-robot-lib/options"
+)
+
+const testConfig = `access:
+  repo_plugins:
+    org:
+    - a
+  plugins:
+  - name: a
+    endpoint: http://localhost:8080/hook
+    events:
+    - Merge Request Hook
+`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRunCommand(t *testing.T) {
+	dir := t.TempDir()
+	cfg := writeFile(t, dir, "config.yaml", testConfig)
+	invalid := writeFile(t, dir, "invalid.yaml", "access:\n  repo_plugins:\n    org: [b]\n")
+	// a lint error, which fails the lint command but not the loading of config
+	badEndpoint := writeFile(t, dir, "endpoint.yaml", "access:\n  plugins:\n  - name: a\n    endpoint: localhost/hook\n    events: [Merge Request Hook]\n  repo_plugins:\n    org: [a]\n")
+	duplicate := writeFile(t, dir, "duplicate.yaml", "access:\n  plugins:\n  - name: a\n    endpoint: http://a/hook\n  - name: a\n    endpoint: http://b/hook\n  repo_plugins:\n    org: [a]\n")
+	debounced := writeFile(t, dir, "debounced.yaml", testConfig+"    debounce:\n      window: 5s\n")
+	flagsFile := writeFile(t, dir, "flags", "# the flags of test\nconfig-file="+cfg+"\n")
+	event := writeFile(t, dir, "event.json", `{"Org":"org","Repo":"repo","EventName":"Merge Request Hook"}`)
+
+	cases := []struct {
+		name string
+		args []string
+		env  map[string]string
+		code int
+		out  string
+	}{
+		{name: "version", args: []string{"version"}, out: botName + " " + version},
+		{name: "help", args: []string{"help"}, out: "Commands:"},
+		{name: "unknown command", args: []string{"unknown"}, code: 2, out: "unknown command: unknown"},
+		{name: "config schema", args: []string{"config-schema"}, out: `"repo_plugins"`},
+		{name: "valid config", args: []string{"validate-config", "--config-file", cfg}, out: "config is valid"},
+		{name: "invalid config", args: []string{"validate-config", "--config-file", invalid}, code: 1, out: "invalid config"},
+		{name: "duplicate plugin", args: []string{"validate-config", "--config-file", duplicate}, code: 1, out: "duplicate plugin: a"},
+		{name: "missing config", args: []string{"validate-config"}, code: 1, out: "missing config-file"},
+		{
+			name: "config by env",
+			args: []string{"validate-config"},
+			env:  map[string]string{"ROBOT_GATEWAY_CONFIG_FILE": cfg},
+			out:  "config is valid",
+		},
+		{name: "config by flags file", args: []string{"validate-config", "--flags-file", flagsFile}, out: "config is valid"},
+		{
+			name: "command line over env",
+			args: []string{"validate-config", "--config-file", invalid},
+			env:  map[string]string{"ROBOT_GATEWAY_CONFIG_FILE": cfg},
+			code: 1,
+			out:  "invalid config",
+		},
+		{
+			name: "env over flags file",
+			args: []string{"validate-config", "--flags-file", flagsFile},
+			env:  map[string]string{"ROBOT_GATEWAY_CONFIG_FILE": invalid},
+			code: 1,
+			out:  "invalid config",
+		},
+		{name: "unknown flag", args: []string{"lint", "--unknown"}, code: 2, out: "flag provided but not defined"},
+		{name: "missing flags file", args: []string{"lint", "--flags-file", "/nonexistent"}, code: 2, out: "/nonexistent"},
+		{name: "lint", args: []string{"lint", "--config-file", cfg}},
+		{name: "lint invalid", args: []string{"lint", "--config-file", invalid}, code: 1, out: "error"},
+		{name: "lint bad endpoint", args: []string{"lint", "--config-file", badEndpoint}, code: 1, out: "error: plugin a: endpoint localhost/hook"},
+		{name: "lint duplicate plugin", args: []string{"lint", "--config-file", duplicate}, code: 1, out: "error: duplicate plugin: a"},
+		{name: "valid config with lint error", args: []string{"validate-config", "--config-file", badEndpoint}, out: "config is valid"},
+		{
+			name: "route explain",
+			args: []string{"route-explain", "--config-file", cfg, "--org", "org", "--repo", "repo", "--event", "Merge Request Hook"},
+			out:  "plugin a: delivered to http://localhost:8080/hook",
+		},
+		{
+			name: "route explain debounced by default key",
+			args: []string{"route-explain", "--config-file", debounced, "--org", "org", "--repo", "repo", "--event", "Merge Request Hook"},
+			out:  "debounced within 5s by org,repo,event,pr,issue,head",
+		},
+		{name: "route explain without org", args: []string{"route-explain", "--config-file", cfg}, code: 2, out: "org and event are required"},
+		{
+			name: "replay",
+			args: []string{"replay", "--config-file", cfg, "--event-file", event, "--dry-run"},
+			out:  "http://localhost:8080/hook: skipped, dry run",
+		},
+		{name: "replay without event", args: []string{"replay"}, code: 2, out: "missing event-file"},
+		{
+			name: "serve with invalid env",
+			env:  map[string]string{"ROBOT_GATEWAY_PORT": "abc"},
+			code: 2,
+			out:  "ROBOT_GATEWAY_PORT",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for k, v := range c.env {
+				t.Setenv(k, v)
+			}
+
+			out := new(bytes.Buffer)
+			if code := runCommand(c.args, out); code != c.code {
+				t.Errorf("expected exit code %d, got %d, output:\n%s", c.code, code, out)
+			}
+
+			if !strings.Contains(out.String(), c.out) {
+				t.Errorf("expected output containing %q, got:\n%s", c.out, out)
+			}
+
+			if c.code == 2 && strings.Count(out.String(), c.out) > 1 && c.out != "" {
+				t.Errorf("expected the error to be written once, got:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestGatherOptionsDefaults(t *testing.T) {
+	var lib liboptions.ServiceOptions
+	fs := flag.NewFlagSet("lib", flag.ContinueOnError)
+	lib.AddFlags(fs)
+	if lib.Workers != 0 {
+		t.Errorf("expected the lib to handle every event at once by default, got %d workers", lib.Workers)
+	}
+
+	opt, err := gatherOptions(newFlagSet("serve", io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.service.Workers != 64 {
+		t.Errorf("expected 64 workers of the gateway by default, got %d", opt.service.Workers)
+	}
+
+	opt, err = gatherOptions(newFlagSet("serve", io.Discard), "--workers", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.service.Workers != 0 {
+		t.Errorf("expected the flag to override the default, got %d workers", opt.service.Workers)
+	}
+}
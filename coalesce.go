@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"community-robot-lib/framework"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// coalesceFields maps the names which can be used in debounceConfig.Key
+// to the value of the event they stand for.
+var coalesceFields = map[string]func(*framework.GenericEvent) string{
+	"org":    func(e *framework.GenericEvent) string { return e.Org },
+	"repo":   func(e *framework.GenericEvent) string { return e.Repo },
+	"event":  func(e *framework.GenericEvent) string { return e.EventName },
+	"action": func(e *framework.GenericEvent) string { return e.Action },
+	"pr":     func(e *framework.GenericEvent) string { return e.PRNumber },
+	"issue":  func(e *framework.GenericEvent) string { return e.IssueNumber },
+	"base":   func(e *framework.GenericEvent) string { return e.Base },
+	"head":   func(e *framework.GenericEvent) string { return e.Head },
+}
+
+var defaultCoalesceKey = []string{"org", "repo", "event", "pr", "issue", "head"}
+
+type debounceConfig struct {
+	// Window is how long the events are collected before the latest one is delivered,
+	// it is counted from the first event of the burst. Such as "5s".
+	Window string `json:"window" required:"true"`
+
+	// Key lists the fields of the event which must be equal for the events to be
+	// coalesced. Each item is one of org, repo, event, action, pr, issue, base and head.
+	// If no key is specified, org, repo, event, pr, issue and head are used.
+	Key []string `json:"key,omitempty"`
+
+	// Events are the events which are coalesced. If no events are specified,
+	// every event delivered to the plugin is coalesced.
+	Events []string `json:"events,omitempty"`
+
+	window time.Duration
+}
+
+func (d *debounceConfig) validate() error {
+	v, err := time.ParseDuration(d.Window)
+	if err != nil {
+		return fmt.Errorf("invalid debounce window: %v", err)
+	}
+	if v <= 0 {
+		return fmt.Errorf("debounce window must be positive")
+	}
+	d.window = v
+
+	for _, k := range d.Key {
+		if _, ok := coalesceFields[k]; !ok {
+			return fmt.Errorf("unknown debounce key: %s", k)
+		}
+	}
+
+	return nil
+}
+
+// applies tells whether the event should be coalesced.
+func (d *debounceConfig) applies(event string) bool {
+	return d != nil && (len(d.Events) == 0 || sets.NewString(d.Events...).Has(event))
+}
+
+// keyFields returns the fields which the events are coalesced by.
+func (d *debounceConfig) keyFields() []string {
+	if len(d.Key) == 0 {
+		return defaultCoalesceKey
+	}
+
+	return d.Key
+}
+
+func (d *debounceConfig) coalesceKey(e *framework.GenericEvent) string {
+	key := d.keyFields()
+
+	v := make([]string, len(key))
+	for i, k := range key {
+		v[i] = coalesceFields[k](e)
+	}
+
+	return strings.Join(v, "|")
+}
+
+type pendingEvent struct {
+	evt        *framework.GenericEvent
+	lgr        *logrus.Entry
+	superseded int
+
+	// gen tells the entry from the later ones of the same key,
+	// so that a stale timer doesn't deliver them
+	gen     uint64
+	timer   *time.Timer
+	deliver func(*framework.GenericEvent, *logrus.Entry)
+}
+
+// send delivers the latest event of the burst.
+func (p *pendingEvent) send() {
+	// the event may be delivered to other plugins too, so it is copied
+	// instead of being changed in place
+	e := *p.evt
+	e.SupersededCount = p.superseded
+
+	lgr := p.lgr
+	if p.superseded > 0 {
+		lgr = lgr.WithField("superseded", p.superseded)
+	}
+
+	p.deliver(&e, lgr)
+}
+
+// debounceKey is the key of a burst, the events of each plugin are coalesced separately.
+type debounceKey struct {
+	plugin string
+	key    string
+}
+
+// debouncer holds the bursts of events back and delivers only the latest event
+// of each burst, together with the number of events it superseded.
+type debouncer struct {
+	mut     sync.Mutex
+	seq     uint64
+	pending map[debounceKey]*pendingEvent
+	// closed is set on shutdown, the events are not held back any more
+	closed bool
+}
+
+func newDebouncer() *debouncer {
+	return &debouncer{pending: make(map[debounceKey]*pendingEvent)}
+}
+
+// submit collects the event under key. The first event of a key starts a window,
+// when the window ends the latest event of the key is passed to deliver.
+// Once the debouncer is closed, the event is passed to deliver at once.
+func (d *debouncer) submit(
+	key debounceKey, window time.Duration,
+	evt *framework.GenericEvent, lgr *logrus.Entry,
+	deliver func(*framework.GenericEvent, *logrus.Entry),
+) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if d.closed {
+		deliver(evt, lgr)
+
+		return
+	}
+
+	if p, ok := d.pending[key]; ok {
+		p.superseded++
+		p.evt = evt
+		p.lgr = lgr
+		p.deliver = deliver
+
+		return
+	}
+
+	d.seq++
+	gen := d.seq
+
+	d.pending[key] = &pendingEvent{
+		evt:     evt,
+		lgr:     lgr,
+		deliver: deliver,
+		gen:     gen,
+		timer: time.AfterFunc(window, func() {
+			d.mut.Lock()
+			p := d.pending[key]
+			if p == nil || p.gen != gen {
+				// dropped or flushed, and maybe replaced by a new burst
+				d.mut.Unlock()
+
+				return
+			}
+			delete(d.pending, key)
+			d.mut.Unlock()
+
+			p.send()
+		}),
+	}
+}
+
+// flush closes the debouncer on shutdown and delivers the pending events
+// at once, so that they are delivered, though early, rather than lost.
+func (d *debouncer) flush() int {
+	d.mut.Lock()
+	d.closed = true
+	pending := d.pending
+	d.pending = make(map[debounceKey]*pendingEvent)
+	d.mut.Unlock()
+
+	for _, p := range pending {
+		p.timer.Stop()
+		p.send()
+	}
+
+	return len(pending)
+}
+
+// drop forgets the pending events of plugin.
+func (d *debouncer) drop(plugin string) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for k, p := range d.pending {
+		if k.plugin == plugin {
+			p.timer.Stop()
+			delete(d.pending, k)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/framework"
+)
+
+func TestDebouncerFlush(t *testing.T) {
+	d := newDebouncer()
+
+	var got []*framework.GenericEvent
+	deliver := func(e *framework.GenericEvent, _ *logrus.Entry) { got = append(got, e) }
+	lgr := logrus.NewEntry(logrus.StandardLogger())
+
+	for _, uuid := range []string{"1", "2", "3"} {
+		evt := &framework.GenericEvent{EventHeader: framework.EventHeader{EventUUID: uuid}}
+		d.submit(debounceKey{plugin: "p", key: "a"}, time.Hour, evt, lgr, deliver)
+	}
+
+	if n := d.flush(); n != 1 {
+		t.Fatalf("expected 1 event flushed, got %d", n)
+	}
+	if len(got) != 1 || got[0].EventUUID != "3" || got[0].SupersededCount != 2 {
+		t.Fatalf("expected the latest event superseding 2, got %+v", got)
+	}
+
+	d.submit(debounceKey{plugin: "p", key: "a"}, time.Hour, &framework.GenericEvent{}, lgr, deliver)
+	if len(got) != 2 {
+		t.Error("expected the event to be delivered at once after flush")
+	}
+}
+
+func TestDebouncerWindow(t *testing.T) {
+	d := newDebouncer()
+
+	var n int32
+	deliver := func(*framework.GenericEvent, *logrus.Entry) { atomic.AddInt32(&n, 1) }
+	lgr := logrus.NewEntry(logrus.StandardLogger())
+
+	d.submit(debounceKey{plugin: "p", key: "a"}, 10*time.Millisecond, &framework.GenericEvent{}, lgr, deliver)
+	d.submit(debounceKey{plugin: "p", key: "a"}, 10*time.Millisecond, &framework.GenericEvent{}, lgr, deliver)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if d.flush() != 0 || atomic.LoadInt32(&n) != 1 {
+		t.Errorf("expected the burst delivered once by the timer, got %d", n)
+	}
+}
+
+func TestDebouncerDropStaleTimer(t *testing.T) {
+	d := newDebouncer()
+
+	var n int32
+	deliver := func(*framework.GenericEvent, *logrus.Entry) { atomic.AddInt32(&n, 1) }
+	lgr := logrus.NewEntry(logrus.StandardLogger())
+	key := debounceKey{plugin: "p", key: "a"}
+
+	d.submit(key, 20*time.Millisecond, &framework.GenericEvent{}, lgr, deliver)
+	d.drop("p")
+	d.submit(debounceKey{plugin: "q", key: "a"}, time.Hour, &framework.GenericEvent{}, lgr, deliver)
+	// a new burst of the dropped key must not be cut short by the former timer
+	d.submit(key, time.Hour, &framework.GenericEvent{}, lgr, deliver)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if got := atomic.LoadInt32(&n); got != 0 {
+		t.Fatalf("expected nothing delivered before the window ends, got %d", got)
+	}
+
+	if got := d.flush(); got != 2 {
+		t.Errorf("expected 2 events pending, got %d", got)
+	}
+}
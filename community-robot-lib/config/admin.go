@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminHandler serves the version history of config, the paths are relative
+// to where the handler is mounted, so it is expected to be wrapped by http.StripPrefix.
+//
+//	GET  versions             list the versions, the newest first
+//	GET  diff?from=md5&to=md5 the changes between two versions
+//	POST pin?md5=md5          pin a version
+//	POST unpin                resume tracking the config file
+func (ca *ConfigAgent) AdminHandler() http.Handler {
+	return http.HandlerFunc(ca.serveAdmin)
+}
+
+type versionsResp struct {
+	Current  string    `json:"current"`
+	Pinned   string    `json:"pinned,omitempty"`
+	Versions []Version `json:"versions"`
+}
+
+func (ca *ConfigAgent) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(r.URL.Path, "/")
+
+	method := http.MethodGet
+	if action == "pin" || action == "unpin" {
+		method = http.MethodPost
+	}
+
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+
+	switch action {
+	case "versions":
+		current, _ := ca.GetConfig()
+		versions, pinned := ca.Versions()
+		writeJSON(w, versionsResp{Current: current, Pinned: pinned, Versions: versions})
+
+	case "diff":
+		changes, err := ca.DiffVersions(q.Get("from"), q.Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if changes == nil {
+			changes = []Change{}
+		}
+		writeJSON(w, changes)
+
+	case "pin":
+		if err := ca.Pin(q.Get("md5")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]string{"pinned": q.Get("md5")})
+
+	case "unpin":
+		ca.Unpin()
+		current, _ := ca.GetConfig()
+		writeJSON(w, map[string]string{"current": current})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// keep the time format of the api stable regardless of the location of server
+func (v Version) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		MD5Sum   string `json:"md5"`
+		LoadedAt string `json:"loaded_at"`
+	}{v.MD5Sum, v.LoadedAt.UTC().Format(time.RFC3339)})
+}
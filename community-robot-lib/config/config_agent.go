@@ -3,14 +3,9 @@ package config
 import (
 	"crypto/md5"
 	"fmt"
-	"os"
 	"sync"
-	"time"
 
 	"github.com/sirupsen/logrus"
-	"sigs.k8s.io/yaml"
-
-	"community-robot-lib/utils"
 )
 
 type Config interface {
@@ -22,32 +17,53 @@ type Config interface {
 // Otherwise, it should deep copy the config when reading it.
 type NewConfig func() Config
 
+// ChangeHandler is called after a new config is loaded,
+// old is nil when the config is loaded for the first time.
+type ChangeHandler func(old, new Config)
+
 type ConfigAgent struct {
 	mut    sync.RWMutex
 	c      Config
 	b      NewConfig
 	md5Sum string
-	t      utils.Timer
+	src    ConfigSource
+
+	hooksMut sync.Mutex
+	hooks    []ChangeHandler
+
+	// switchMut serializes the switches of config, which are
+	// caused by loading the file, pinning and unpinning.
+	switchMut    sync.Mutex
+	history      []Version
+	historySize  int
+	sourceMD5Sum string
+	pinned       string
+
+	unknownFields string
 }
 
 func NewConfigAgent(b NewConfig) ConfigAgent {
-	return ConfigAgent{b: b, t: utils.NewTimer()}
+	return ConfigAgent{b: b, historySize: DefaultHistorySize, unknownFields: UnknownFieldsReject}
 }
 
-func (ca *ConfigAgent) load(path string) error {
-	b, err := os.ReadFile(path)
+// load reads the config from src, and applies it if it changed and is valid.
+func (ca *ConfigAgent) load(src ConfigSource) error {
+	content, err := src.Read()
 	if err != nil {
 		return err
 	}
 
-	content := []byte(os.ExpandEnv(string(b)))
 	md5Sum := fmt.Sprintf("%x", md5.Sum(content))
-	if ca.md5Sum == md5Sum {
+
+	ca.switchMut.Lock()
+	defer ca.switchMut.Unlock()
+
+	if ca.sourceMD5Sum == md5Sum {
 		return nil
 	}
 
 	c := ca.b()
-	if err := yaml.Unmarshal(content, c); err != nil {
+	if err := unmarshal(content, c, ca.unknownFields, logrus.WithField("source", src.String())); err != nil {
 		return err
 	}
 
@@ -57,12 +73,73 @@ func (ca *ConfigAgent) load(path string) error {
 		return err
 	}
 
+	ca.record(md5Sum, c)
+	ca.sourceMD5Sum = md5Sum
+
+	if ca.pinned != "" {
+		logrus.WithFields(logrus.Fields{
+			"pinned": ca.pinned,
+			"loaded": md5Sum,
+		}).Info("config is pinned, the loaded one is kept in history only")
+
+		return nil
+	}
+
+	ca.apply(md5Sum, c)
+
+	return nil
+}
+
+// apply makes c the current config. It must be called with switchMut held.
+func (ca *ConfigAgent) apply(md5Sum string, c Config) {
 	ca.mut.Lock()
+	old := ca.c
 	ca.c = c
 	ca.md5Sum = md5Sum
 	ca.mut.Unlock()
 
-	return nil
+	ca.notify(old, c)
+}
+
+// OnChange registers a handler which is called every time a new config is loaded.
+// The handlers are called one after another in the order they are registered.
+// Register them before Start, so that they see the first load as well.
+func (ca *ConfigAgent) OnChange(h ChangeHandler) {
+	ca.hooksMut.Lock()
+	ca.hooks = append(ca.hooks, h)
+	ca.hooksMut.Unlock()
+}
+
+func (ca *ConfigAgent) notify(old, c Config) {
+	if old != nil {
+		logChanges(old, c)
+	}
+
+	ca.hooksMut.Lock()
+	defer ca.hooksMut.Unlock()
+
+	for _, h := range ca.hooks {
+		h(old, c)
+	}
+}
+
+// logChanges writes the differences of the configs to the log, one entry per change.
+func logChanges(old, c Config) {
+	changes, err := Diff(old, c)
+	if err != nil {
+		logrus.WithError(err).Warn("can't compare the configs")
+
+		return
+	}
+
+	for i := range changes {
+		logrus.WithFields(logrus.Fields{
+			"path":   changes[i].Path,
+			"change": changes[i].Kind,
+			"old":    changes[i].Old,
+			"new":    changes[i].New,
+		}).Info("config changed")
+	}
 }
 
 func (ca *ConfigAgent) GetConfig() (string, Config) {
@@ -74,28 +151,35 @@ func (ca *ConfigAgent) GetConfig() (string, Config) {
 	return v, c
 }
 
-// Start starts polling path for plugin config.
+// Start starts watching path for plugin config, the config is reloaded
+// as soon as it changes. The path is either a url of http(s), a file,
+// a directory or a glob, see NewConfigSource.
 // If the first attempt fails, then start returns the error.
 func (ca *ConfigAgent) Start(path string) error {
-	if err := ca.load(path); err != nil {
+	return ca.StartSource(NewConfigSource(path))
+}
+
+// StartSource starts watching src for plugin config.
+// If the first attempt fails, then start returns the error.
+func (ca *ConfigAgent) StartSource(src ConfigSource) error {
+	if err := ca.load(src); err != nil {
 		return err
 	}
 
-	l := logrus.WithField("path", path)
+	l := logrus.WithField("source", src.String())
 
-	ca.t.Start(
-		func() {
-			if err := ca.load(path); err != nil {
-				l.WithError(err).Error("loading config")
-			}
-		},
-		1*time.Minute,
-		0,
-	)
+	ca.src = src
+	src.Watch(func() {
+		if err := ca.load(src); err != nil {
+			l.WithError(err).Error("loading config")
+		}
+	})
 
 	return nil
 }
 
 func (ca *ConfigAgent) Stop() {
-	ca.t.Stop()
+	if ca.src != nil {
+		ca.src.Stop()
+	}
 }
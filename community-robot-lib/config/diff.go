@@ -0,0 +1,192 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeUpdated = "updated"
+)
+
+// Change is a difference between two configs.
+type Change struct {
+	// Path locates the changed item by the json names of the fields, such as
+	// access.plugins[name=foo].endpoint. The items of a list which have a name
+	// are located by it, the others by their index.
+	Path string `json:"path"`
+
+	// Kind is one of added, removed and updated.
+	Kind string `json:"kind"`
+
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("%s %s: %v", c.Kind, c.Path, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("%s %s: %v", c.Kind, c.Path, c.Old)
+	default:
+		return fmt.Sprintf("%s %s: %v => %v", c.Kind, c.Path, c.Old, c.New)
+	}
+}
+
+// Diff returns the changes from old to new, either of them can be nil.
+// The configs are compared by their json form.
+func Diff(old, new Config) ([]Change, error) {
+	o, err := toTree(old)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := toTree(new)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	diffTree("", o, n, &changes)
+
+	return changes, nil
+}
+
+func toTree(c Config) (interface{}, error) {
+	if c == nil || reflect.ValueOf(c).IsNil() {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	err = json.Unmarshal(b, &v)
+
+	return v, err
+}
+
+func diffTree(path string, o, n interface{}, changes *[]Change) {
+	switch {
+	case o == nil && n == nil:
+		return
+	case o == nil:
+		*changes = append(*changes, Change{Path: path, Kind: ChangeAdded, New: n})
+		return
+	case n == nil:
+		*changes = append(*changes, Change{Path: path, Kind: ChangeRemoved, Old: o})
+		return
+	}
+
+	om, ok1 := o.(map[string]interface{})
+	nm, ok2 := n.(map[string]interface{})
+	if ok1 && ok2 {
+		diffMap(path, om, nm, changes)
+		return
+	}
+
+	ol, ok1 := o.([]interface{})
+	nl, ok2 := n.([]interface{})
+	if ok1 && ok2 {
+		diffList(path, ol, nl, changes)
+		return
+	}
+
+	if !reflect.DeepEqual(o, n) {
+		*changes = append(*changes, Change{Path: path, Kind: ChangeUpdated, Old: o, New: n})
+	}
+}
+
+func diffMap(path string, o, n map[string]interface{}, changes *[]Change) {
+	keys := make([]string, 0, len(o)+len(n))
+	for k := range o {
+		keys = append(keys, k)
+	}
+	for k := range n {
+		if _, ok := o[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+
+		diffTree(p, o[k], n[k], changes)
+	}
+}
+
+// diffList compares the items by their names if all of them have one,
+// otherwise by their indexes.
+func diffList(path string, o, n []interface{}, changes *[]Change) {
+	on, ok1 := namedItems(o)
+	nn, ok2 := namedItems(n)
+	if !ok1 || !ok2 {
+		max := len(o)
+		if len(n) > max {
+			max = len(n)
+		}
+
+		for i := 0; i < max; i++ {
+			var ov, nv interface{}
+			if i < len(o) {
+				ov = o[i]
+			}
+			if i < len(n) {
+				nv = n[i]
+			}
+
+			diffTree(fmt.Sprintf("%s[%d]", path, i), ov, nv, changes)
+		}
+
+		return
+	}
+
+	names := make([]string, 0, len(on)+len(nn))
+	for k := range on {
+		names = append(names, k)
+	}
+	for k := range nn {
+		if _, ok := on[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		diffTree(fmt.Sprintf("%s[name=%s]", path, k), on[k], nn[k], changes)
+	}
+}
+
+func namedItems(l []interface{}) (map[string]interface{}, bool) {
+	m := make(map[string]interface{}, len(l))
+	for _, item := range l {
+		v, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		name, ok := v["name"].(string)
+		if !ok || name == "" {
+			return nil, false
+		}
+
+		if _, ok := m[name]; ok {
+			return nil, false
+		}
+
+		m[name] = item
+	}
+
+	return m, true
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testPlugin struct {
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint"`
+	Events   []string `json:"events,omitempty"`
+}
+
+type testConfig struct {
+	RepoPlugins map[string][]string `json:"repo_plugins,omitempty"`
+	Plugins     []testPlugin        `json:"plugins,omitempty"`
+}
+
+func (c *testConfig) Validate() error { return nil }
+func (c *testConfig) SetDefault()     {}
+
+func TestDiff(t *testing.T) {
+	old := &testConfig{
+		RepoPlugins: map[string][]string{"org": {"a"}},
+		Plugins: []testPlugin{
+			{Name: "a", Endpoint: "http://a"},
+			{Name: "b", Endpoint: "http://b"},
+		},
+	}
+	new := &testConfig{
+		RepoPlugins: map[string][]string{"org": {"a"}, "org/repo": {"c"}},
+		Plugins: []testPlugin{
+			{Name: "c", Endpoint: "http://c"},
+			{Name: "a", Endpoint: "http://a2"},
+		},
+	}
+
+	changes, err := Diff(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.Kind+" "+c.Path)
+	}
+
+	expected := []string{
+		"updated plugins[name=a].endpoint",
+		"removed plugins[name=b]",
+		"added plugins[name=c]",
+		"added repo_plugins.org/repo",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if changes, _ := Diff(nil, old); len(changes) != 1 || changes[0].Kind != ChangeAdded {
+		t.Errorf("expected the whole config to be added, got %v", changes)
+	}
+}
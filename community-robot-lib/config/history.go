@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultHistorySize is the number of validated configs kept by ConfigAgent.
+const DefaultHistorySize = 10
+
+// Version is a validated config which was loaded by ConfigAgent.
+type Version struct {
+	MD5Sum   string    `json:"md5"`
+	LoadedAt time.Time `json:"loaded_at"`
+
+	c Config
+}
+
+// Config returns the config of the version.
+func (v *Version) Config() Config {
+	return v.c
+}
+
+// SetHistorySize changes the number of validated configs kept in history,
+// it can't be less than 1.
+func (ca *ConfigAgent) SetHistorySize(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	ca.switchMut.Lock()
+	ca.historySize = n
+	ca.trim()
+	ca.switchMut.Unlock()
+}
+
+// record appends the config to history, a config which is already in history
+// is moved to the end. It must be called with switchMut held.
+func (ca *ConfigAgent) record(md5Sum string, c Config) {
+	for i := range ca.history {
+		if ca.history[i].MD5Sum == md5Sum {
+			ca.history = append(ca.history[:i], ca.history[i+1:]...)
+
+			break
+		}
+	}
+
+	ca.history = append(ca.history, Version{MD5Sum: md5Sum, LoadedAt: time.Now(), c: c})
+	ca.trim()
+}
+
+// trim drops the oldest versions beyond the history size, but never the pinned one.
+// It must be called with switchMut held.
+func (ca *ConfigAgent) trim() {
+	for i := 0; len(ca.history) > ca.historySize && i < len(ca.history); {
+		if ca.history[i].MD5Sum == ca.pinned {
+			i++
+
+			continue
+		}
+
+		ca.history = append(ca.history[:i], ca.history[i+1:]...)
+	}
+}
+
+func (ca *ConfigAgent) find(md5Sum string) *Version {
+	for i := range ca.history {
+		if ca.history[i].MD5Sum == md5Sum {
+			v := ca.history[i]
+
+			return &v
+		}
+	}
+
+	return nil
+}
+
+// Versions returns the validated configs in history, the newest first,
+// and the md5 of the pinned one which is empty if no one is pinned.
+func (ca *ConfigAgent) Versions() ([]Version, string) {
+	ca.switchMut.Lock()
+	defer ca.switchMut.Unlock()
+
+	v := make([]Version, len(ca.history))
+	for i := range ca.history {
+		v[len(v)-1-i] = ca.history[i]
+	}
+
+	return v, ca.pinned
+}
+
+// DiffVersions returns the changes from the version of md5 from to the version of md5 to.
+func (ca *ConfigAgent) DiffVersions(from, to string) ([]Change, error) {
+	ca.switchMut.Lock()
+	f, t := ca.find(from), ca.find(to)
+	ca.switchMut.Unlock()
+
+	if f == nil {
+		return nil, fmt.Errorf("unknown version: %s", from)
+	}
+
+	if t == nil {
+		return nil, fmt.Errorf("unknown version: %s", to)
+	}
+
+	return Diff(f.c, t.c)
+}
+
+// Pin makes the version of md5Sum the current config, and keeps it
+// regardless of the changes of the config file until Unpin is called.
+func (ca *ConfigAgent) Pin(md5Sum string) error {
+	ca.switchMut.Lock()
+	defer ca.switchMut.Unlock()
+
+	v := ca.find(md5Sum)
+	if v == nil {
+		return fmt.Errorf("unknown version: %s", md5Sum)
+	}
+
+	ca.pinned = md5Sum
+
+	if _, current := ca.GetConfig(); current != v.c {
+		ca.apply(md5Sum, v.c)
+	}
+
+	return nil
+}
+
+// Unpin resumes tracking the config file, the latest config loaded
+// from the file becomes the current one.
+func (ca *ConfigAgent) Unpin() {
+	ca.switchMut.Lock()
+	defer ca.switchMut.Unlock()
+
+	if ca.pinned == "" {
+		return
+	}
+
+	ca.pinned = ""
+	ca.trim()
+
+	if v := ca.find(ca.sourceMD5Sum); v != nil && v.MD5Sum != ca.md5Sum {
+		ca.apply(v.MD5Sum, v.c)
+	}
+}
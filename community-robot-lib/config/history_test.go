@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPinAndUnpin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	write := func(endpoint string) {
+		content := "plugins:\n- name: a\n  endpoint: " + endpoint + "\n"
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	endpoint := func(ca *ConfigAgent) string {
+		_, c := ca.GetConfig()
+
+		return c.(*testConfig).Plugins[0].Endpoint
+	}
+
+	ca := NewConfigAgent(func() Config { return new(testConfig) })
+	ca.SetHistorySize(2)
+
+	var changes int
+	ca.OnChange(func(old, new Config) { changes++ })
+
+	write("http://v1")
+	if err := ca.load(NewFileSource(path)); err != nil {
+		t.Fatal(err)
+	}
+	v1, _ := ca.GetConfig()
+
+	write("http://v2")
+	if err := ca.load(NewFileSource(path)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ca.Pin(v1); err != nil {
+		t.Fatal(err)
+	}
+	if v := endpoint(&ca); v != "http://v1" {
+		t.Fatalf("expected the pinned config, got %s", v)
+	}
+
+	// the file is still tracked in history, but not applied
+	write("http://v3")
+	if err := ca.load(NewFileSource(path)); err != nil {
+		t.Fatal(err)
+	}
+	if v := endpoint(&ca); v != "http://v1" {
+		t.Fatalf("expected the pinned config to be kept, got %s", v)
+	}
+
+	versions, pinned := ca.Versions()
+	if pinned != v1 || len(versions) != 2 || versions[1].MD5Sum != v1 {
+		t.Fatalf("expected the pinned version to stay in history, got %v pinned %s", versions, pinned)
+	}
+
+	ca.Unpin()
+	if v := endpoint(&ca); v != "http://v3" {
+		t.Fatalf("expected the latest config of file after unpinning, got %s", v)
+	}
+
+	// v1, v2, pinning v1 and unpinning to v3
+	if changes != 4 {
+		t.Errorf("expected 4 changes, got %d", changes)
+	}
+
+	if err := ca.Pin("unknown"); err == nil {
+		t.Error("expected error when pinning an unknown version")
+	}
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// fragment is a piece of config which is read from one file.
+type fragment struct {
+	path    string
+	content []byte
+}
+
+// isGlob tells whether path is a pattern of filepath.Match.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// resolveFiles returns the config files which path stands for. The path is
+// either a file, a directory whose yaml and json files are used, or a glob.
+// The files are sorted by name, hidden files are skipped.
+func resolveFiles(path string) ([]string, error) {
+	if isGlob(path) {
+		files, err := filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+
+		files = filterConfigFiles(files, false)
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no config file matches %s", path)
+		}
+
+		return files, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		files = append(files, filepath.Join(path, e.Name()))
+	}
+
+	files = filterConfigFiles(files, true)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no config file in %s", path)
+	}
+
+	return files, nil
+}
+
+func filterConfigFiles(files []string, checkExt bool) []string {
+	ans := make([]string, 0, len(files))
+	for _, f := range files {
+		if strings.HasPrefix(filepath.Base(f), ".") {
+			continue
+		}
+
+		if checkExt {
+			switch filepath.Ext(f) {
+			case ".yaml", ".yml", ".json":
+			default:
+				continue
+			}
+		}
+
+		// os.Stat follows symlinks, such as the ones in the volume of ConfigMap
+		if info, err := os.Stat(f); err != nil || info.IsDir() {
+			continue
+		}
+
+		ans = append(ans, f)
+	}
+
+	sort.Strings(ans)
+
+	return ans
+}
+
+func readFragments(files []string) ([]fragment, error) {
+	frags := make([]fragment, 0, len(files))
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		frags = append(frags, fragment{path: f, content: []byte(os.ExpandEnv(string(b)))})
+	}
+
+	return frags, nil
+}
+
+// mergeFragments deep-merges the fragments into one json document. Maps are
+// merged, lists are appended in the order of the fragments, and a value other
+// than map or list defined differently by two fragments is a conflict.
+// The items of list which have a name, such as plugins, are a conflict if two
+// fragments define the same name, and the duplicated scalars of list, such as
+// the plugins of repo_plugins, are dropped.
+func mergeFragments(frags []fragment) ([]byte, error) {
+	var merged map[string]interface{}
+	// origin records which file each leaf value comes from
+	origin := map[string]string{}
+
+	for _, f := range frags {
+		b, err := yaml.YAMLToJSON(f.content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.path, err)
+		}
+
+		var v map[string]interface{}
+		if len(bytes.TrimSpace(b)) > 0 && string(bytes.TrimSpace(b)) != "null" {
+			if err := json.Unmarshal(b, &v); err != nil {
+				return nil, fmt.Errorf("%s: the config must be a map: %v", f.path, err)
+			}
+		}
+
+		if merged == nil {
+			merged = map[string]interface{}{}
+		}
+
+		if err := mergeMap("", merged, v, f.path, origin); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(merged)
+}
+
+func mergeMap(path string, dst, src map[string]interface{}, file string, origin map[string]string) error {
+	for k, sv := range src {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+
+		dv, ok := dst[k]
+		if !ok {
+			dst[k] = sv
+			setOrigin(p, sv, file, origin)
+
+			continue
+		}
+
+		dm, ok1 := dv.(map[string]interface{})
+		sm, ok2 := sv.(map[string]interface{})
+		if ok1 && ok2 {
+			if err := mergeMap(p, dm, sm, file, origin); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		dl, ok1 := dv.([]interface{})
+		sl, ok2 := sv.([]interface{})
+		if ok1 && ok2 {
+			v, err := mergeList(p, dl, sl, file, origin)
+			if err != nil {
+				return err
+			}
+			dst[k] = v
+
+			continue
+		}
+
+		if !reflect.DeepEqual(dv, sv) {
+			return fmt.Errorf(
+				"conflict at %s: %s defines %v, %s defines %v",
+				p, origin[p], dv, file, sv,
+			)
+		}
+	}
+
+	return nil
+}
+
+// mergeList appends the items of src to dst, see mergeFragments.
+func mergeList(path string, dst, src []interface{}, file string, origin map[string]string) ([]interface{}, error) {
+	for _, sv := range src {
+		if name, ok := itemName(sv); ok {
+			p := path + "[" + name + "]"
+			if f, ok := origin[p]; ok && f != file {
+				return nil, fmt.Errorf("conflict at %s: both %s and %s define it", p, f, file)
+			}
+
+			dst = append(dst, sv)
+			setOrigin(p, sv, file, origin)
+
+			continue
+		}
+
+		if _, ok := sv.(map[string]interface{}); !ok && containsItem(dst, sv) {
+			continue
+		}
+
+		dst = append(dst, sv)
+	}
+
+	return dst, nil
+}
+
+// itemName returns the name of the item of list if it is a map with a name.
+func itemName(v interface{}) (string, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+
+	name, ok := m["name"].(string)
+
+	return name, ok && name != ""
+}
+
+func containsItem(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setOrigin(path string, v interface{}, file string, origin map[string]string) {
+	origin[path] = file
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, sv := range t {
+			setOrigin(path+"."+k, sv, file, origin)
+		}
+
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := itemName(item); ok {
+				setOrigin(path+"["+name+"]", item, file, origin)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"plugins.yaml": "plugins:\n- name: a\n  endpoint: http://a\n",
+		"org1.yaml":    "repo_plugins:\n  org1: [a]\n",
+		"org2.yml":     "repo_plugins:\n  org2: [a]\n",
+		".hidden.yaml": "plugins: broken",
+		"README.md":    "not a config",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ca := NewConfigAgent(func() Config { return new(testConfig) })
+	if err := ca.load(NewFileSource(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, v := ca.GetConfig()
+	c := v.(*testConfig)
+	if len(c.Plugins) != 1 || len(c.RepoPlugins) != 2 {
+		t.Fatalf("unexpected merged config: %+v", c)
+	}
+
+	if err := ca.load(NewFileSource(filepath.Join(dir, "org*.y*ml"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, v = ca.GetConfig(); len(v.(*testConfig).Plugins) != 0 {
+		t.Fatalf("expected only the files matching the glob to be loaded: %+v", v)
+	}
+
+	// the same org is routed by two files with different values
+	conflict := "plugins:\n- name: b\n  endpoint: http://b\nrepo_plugins:\n  org1: a\n"
+	if err := os.WriteFile(filepath.Join(dir, "zz.yaml"), []byte(conflict), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ca.load(NewFileSource(dir))
+	if err == nil || !strings.Contains(err.Error(), "org1.yaml") || !strings.Contains(err.Error(), "zz.yaml") {
+		t.Fatalf("expected a conflict naming both files, got %v", err)
+	}
+}
+
+func writeFragments(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestMergeDuplicatePlugin(t *testing.T) {
+	dir := writeFragments(t, map[string]string{
+		"a.yaml": "plugins:\n- name: a\n  endpoint: http://a\n",
+		"b.yaml": "plugins:\n- name: b\n  endpoint: http://b\n- name: a\n  endpoint: http://a2\n",
+	})
+
+	ca := NewConfigAgent(func() Config { return new(testConfig) })
+
+	err := ca.load(NewFileSource(dir))
+	if err == nil || !strings.Contains(err.Error(), "plugins[a]") ||
+		!strings.Contains(err.Error(), "a.yaml") || !strings.Contains(err.Error(), "b.yaml") {
+		t.Fatalf("expected a conflict of plugin a naming both files, got %v", err)
+	}
+}
+
+func TestMergeDuplicateRoute(t *testing.T) {
+	dir := writeFragments(t, map[string]string{
+		"a.yaml": "plugins:\n- name: a\n  endpoint: http://a\nrepo_plugins:\n  org1: [a]\n",
+		"b.yaml": "repo_plugins:\n  org1: [a, b]\n",
+	})
+
+	ca := NewConfigAgent(func() Config { return new(testConfig) })
+	if err := ca.load(NewFileSource(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, v := ca.GetConfig()
+	if got := v.(*testConfig).RepoPlugins["org1"]; strings.Join(got, ",") != "a,b" {
+		t.Errorf("expected the plugins of org1 to be deduplicated, got %v", got)
+	}
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// envPathSeparator separates the keys of the path in the name of env var.
+const envPathSeparator = "__"
+
+// envKeyEscapes are the escapes of the characters which can't be in the name of env var.
+var envKeyEscapes = strings.NewReplacer("_x2f", "/", "_x2F", "/", "_x2d", "-", "_x2D", "-")
+
+// envOverlay overrides individual keys of the config read from src by the env vars
+// whose names start with prefix. The rest of the name is the path of the key,
+// the keys are separated by double underscores and matched case-insensitively,
+// a key which doesn't exist yet is added in the case written in the name.
+// A "/" in a key is written as _x2f and a "-" as _x2d.
+// An item of list is located by its index or by its name. The value is parsed as yaml.
+//
+// For example, with the prefix ROBOT_CONFIG_:
+//
+//	ROBOT_CONFIG_ACCESS__PLUGINS__ROBOT_x2dLABEL__ENDPOINT=http://label:8888/hook
+//	ROBOT_CONFIG_ACCESS__REPO_PLUGINS__openeuler_x2fcommunity='[robot-label, welcome]'
+type envOverlay struct {
+	ConfigSource
+
+	prefix string
+}
+
+// WithEnvOverlay returns a source which applies the env vars of prefix
+// to the config read from src.
+func WithEnvOverlay(src ConfigSource, prefix string) ConfigSource {
+	return &envOverlay{ConfigSource: src, prefix: prefix}
+}
+
+func (s *envOverlay) Read() ([]byte, error) {
+	b, err := s.ConfigSource.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	vars := s.vars()
+	if len(vars) == 0 {
+		return b, nil
+	}
+
+	if b, err = yaml.YAMLToJSON(b); err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		tree = map[string]interface{}{}
+	}
+
+	for _, k := range sortedKeys(vars) {
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(vars[k]), &v); err != nil {
+			return nil, fmt.Errorf("env %s%s: %v", s.prefix, k, err)
+		}
+
+		if err := setPath(tree, envKeyPath(k), v); err != nil {
+			return nil, fmt.Errorf("env %s%s: %v", s.prefix, k, err)
+		}
+	}
+
+	return json.Marshal(tree)
+}
+
+// vars returns the env vars of the prefix, keyed by the path.
+func (s *envOverlay) vars() map[string]string {
+	m := map[string]string{}
+	for _, kv := range os.Environ() {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(k, s.prefix) || k == s.prefix {
+			continue
+		}
+
+		m[strings.TrimPrefix(k, s.prefix)] = v
+	}
+
+	return m
+}
+
+func (s *envOverlay) String() string {
+	return s.ConfigSource.String() + " with env overlay " + s.prefix
+}
+
+// envKeyPath splits the name of env var into the path of the key and unescapes the keys.
+func envKeyPath(name string) []string {
+	path := strings.Split(name, envPathSeparator)
+	for i := range path {
+		path[i] = envKeyEscapes.Replace(path[i])
+	}
+
+	return path
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// setPath sets the value at path of node, the maps on the path are created if missing.
+func setPath(node interface{}, path []string, v interface{}) error {
+	key := path[0]
+	last := len(path) == 1
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		k := matchKey(n, key)
+
+		if last {
+			n[k] = v
+
+			return nil
+		}
+
+		child, ok := n[k]
+		if !ok || child == nil {
+			child = map[string]interface{}{}
+			n[k] = child
+		}
+
+		return setPath(child, path[1:], v)
+
+	case []interface{}:
+		i := matchItem(n, key)
+		if i < 0 {
+			return fmt.Errorf("no item %s in the list", key)
+		}
+
+		if last {
+			n[i] = v
+
+			return nil
+		}
+
+		return setPath(n[i], path[1:], v)
+
+	default:
+		return fmt.Errorf("can't set %s on a value which is neither map nor list", key)
+	}
+}
+
+// matchKey returns the existing key which equals to key case-insensitively,
+// or key itself if there is none.
+func matchKey(m map[string]interface{}, key string) string {
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+
+	return key
+}
+
+// matchItem returns the index of the item which key stands for, either
+// the index itself or the name of item.
+func matchItem(l []interface{}, key string) int {
+	if i, err := strconv.Atoi(key); err == nil {
+		if i >= 0 && i < len(l) {
+			return i
+		}
+
+		return -1
+	}
+
+	for i := range l {
+		if m, ok := l[i].(map[string]interface{}); ok {
+			if name, ok := m["name"].(string); ok && strings.EqualFold(name, key) {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
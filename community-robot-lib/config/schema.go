@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// GenerateSchema returns the JSON Schema of the config which c is an instance of.
+// The properties are named by the json tags of the fields, and the fields with
+// the tag of required:"true" are required. Unknown properties are not allowed,
+// in line with ConfigAgent rejecting unknown fields.
+func GenerateSchema(c Config) ([]byte, error) {
+	s := schemaOf(reflect.TypeOf(c), map[reflect.Type]bool{})
+	s["$schema"] = schemaDraft
+
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == durationType {
+		return map[string]interface{}{"type": "integer", "description": "nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as base64 string
+			return map[string]interface{}{"type": "string"}
+		}
+
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), visiting)}
+
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem(), visiting),
+		}
+
+	case reflect.Struct:
+		if visiting[t] {
+			// recursive type, it is left unconstrained
+			return map[string]interface{}{"type": "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		props := map[string]interface{}{}
+		var required []string
+		structFields(t, props, &required, visiting)
+
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+
+		return s
+
+	default:
+		// interface and the others which can hold anything
+		return map[string]interface{}{}
+	}
+}
+
+func structFields(t reflect.Type, props map[string]interface{}, required *[]string, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name, ok := jsonName(f)
+		if !ok {
+			continue
+		}
+
+		// the fields of embedded struct without json name are inlined as encoding/json does
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				structFields(ft, props, required, visiting)
+
+				continue
+			}
+
+			name = f.Name
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = schemaOf(f.Type, visiting)
+
+		if f.Tag.Get("required") == "true" {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// jsonName returns the name of field in json, it is empty if the tag
+// doesn't specify one. false is returned if the field is not encoded.
+func jsonName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() && !f.Anonymous {
+		return "", false
+	}
+
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+
+	return name, true
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testRequired struct {
+	Name  string `json:"name" required:"true"`
+	Skip  string `json:"-"`
+	inner string
+}
+
+type testSchemaConfig struct {
+	testConfig
+
+	Items []testRequired `json:"items,omitempty"`
+}
+
+func (c *testSchemaConfig) Validate() error { return nil }
+func (c *testSchemaConfig) SetDefault()     {}
+
+func TestGenerateSchema(t *testing.T) {
+	b, err := GenerateSchema(&testSchemaConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s struct {
+		Properties map[string]struct {
+			Type  string `json:"type"`
+			Items struct {
+				Properties map[string]interface{} `json:"properties"`
+				Required   []string               `json:"required"`
+			} `json:"items"`
+		} `json:"properties"`
+		AdditionalProperties bool `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for k := range s.Properties {
+		names = append(names, k)
+	}
+	if len(names) != 3 || s.Properties["repo_plugins"].Type != "object" || s.Properties["plugins"].Type != "array" {
+		t.Fatalf("expected the embedded fields to be inlined, got %s", b)
+	}
+
+	items := s.Properties["items"].Items
+	if !reflect.DeepEqual(items.Required, []string{"name"}) || len(items.Properties) != 1 {
+		t.Errorf("unexpected schema of items: %+v", items)
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("repo_plugin:\n  org: [a]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ca := NewConfigAgent(func() Config { return new(testConfig) })
+	if err := ca.load(NewFileSource(path)); err == nil {
+		t.Fatal("expected the unknown field to be rejected")
+	}
+
+	if err := ca.SetUnknownFieldsMode(UnknownFieldsWarn); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.load(NewFileSource(path)); err != nil {
+		t.Fatalf("expected the unknown field to be ignored, got %v", err)
+	}
+}
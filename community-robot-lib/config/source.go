@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"community-robot-lib/utils"
+)
+
+// DefaultURLPollInterval is how often a config served over http(s) is polled.
+const DefaultURLPollInterval = 30 * time.Second
+
+// ConfigSource provides the content of config to ConfigAgent.
+type ConfigSource interface {
+	// Read returns the whole content of config, which is yaml or json.
+	Read() ([]byte, error)
+
+	// Watch calls onChange whenever the content may have changed, until Stop is called.
+	// The content is compared by its md5, so onChange can be called when nothing changed.
+	Watch(onChange func())
+	Stop()
+
+	String() string
+}
+
+// NewConfigSource returns the source which path stands for: a url
+// of http or https, or else a file, a directory or a glob of files.
+func NewConfigSource(path string) ConfigSource {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return NewURLSource(path, DefaultURLPollInterval)
+	}
+
+	return NewFileSource(path)
+}
+
+// fileSource reads a file, or the files of a directory or a glob
+// which are merged, see mergeFragments.
+type fileSource struct {
+	path string
+	w    *utils.FileWatcher
+}
+
+func NewFileSource(path string) ConfigSource {
+	return &fileSource{path: path}
+}
+
+func (s *fileSource) Read() ([]byte, error) {
+	files, err := resolveFiles(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	frags, err := readFragments(files)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(frags) == 1 {
+		return frags[0].content, nil
+	}
+
+	return mergeFragments(frags)
+}
+
+func (s *fileSource) Watch(onChange func()) {
+	s.w = utils.NewFileWatcher(s.path, onChange, utils.DefaultWatchDebounce, utils.DefaultWatchPollInterval)
+	s.w.Start()
+}
+
+func (s *fileSource) Stop() {
+	if s.w != nil {
+		s.w.Stop()
+	}
+}
+
+func (s *fileSource) String() string {
+	return s.path
+}
+
+// urlSource polls a config served over http(s). The ETag and Last-Modified of
+// the response are sent back by If-None-Match and If-Modified-Since, so that
+// the server can reply 304 when the config has not changed.
+type urlSource struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	t        utils.Timer
+
+	mut          sync.Mutex
+	etag         string
+	lastModified string
+	content      []byte
+}
+
+func NewURLSource(url string, interval time.Duration) ConfigSource {
+	if interval <= 0 {
+		interval = DefaultURLPollInterval
+	}
+
+	return &urlSource{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *urlSource) Read() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.content != nil {
+		if s.etag != "" {
+			req.Header.Set("If-None-Match", s.etag)
+		}
+		if s.lastModified != "" {
+			req.Header.Set("If-Modified-Since", s.lastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && s.content != nil:
+		return s.content, nil
+
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("fetching config from %s, status: %s", s.url, resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.content = b
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+
+	return b, nil
+}
+
+func (s *urlSource) Watch(onChange func()) {
+	s.t = utils.NewTimer()
+	s.t.Start(onChange, s.interval, 0)
+}
+
+func (s *urlSource) Stop() {
+	if s.t != nil {
+		s.t.Stop()
+	}
+}
+
+func (s *urlSource) String() string {
+	return s.url
+}
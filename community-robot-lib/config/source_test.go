@@ -0,0 +1,80 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestURLSourceUsesETag(t *testing.T) {
+	content := "plugins:\n- name: a\n  endpoint: http://a\n"
+	var full, notModified int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		full++
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	src := NewConfigSource(srv.URL)
+	for i := 0; i < 2; i++ {
+		b, err := src.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Fatalf("unexpected content: %s", b)
+		}
+	}
+
+	if full != 1 || notModified != 1 {
+		t.Errorf("expected 1 full and 1 conditional response, got %d and %d", full, notModified)
+	}
+}
+
+func TestEnvOverlay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "plugins:\n- name: a\n  endpoint: http://a\n- name: robot-b\n  endpoint: http://b\nrepo_plugins:\n  org: [a]\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_CONFIG_PLUGINS__A__ENDPOINT", "http://overridden")
+	t.Setenv("TEST_CONFIG_REPO_PLUGINS__Org2", "[a]")
+	t.Setenv("TEST_CONFIG_REPO_PLUGINS__Org_x2fRepo_x2dA", "[robot-b]")
+	t.Setenv("TEST_CONFIG_PLUGINS__ROBOT_x2dB__ENDPOINT", "http://overridden-b")
+
+	ca := NewConfigAgent(func() Config { return new(testConfig) })
+	if err := ca.load(WithEnvOverlay(NewFileSource(path), "TEST_CONFIG_")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, v := ca.GetConfig()
+	c := v.(*testConfig)
+	if c.Plugins[0].Endpoint != "http://overridden" {
+		t.Errorf("expected the endpoint to be overridden, got %s", c.Plugins[0].Endpoint)
+	}
+	if c.Plugins[1].Endpoint != "http://overridden-b" {
+		t.Errorf("expected the endpoint of escaped name to be overridden, got %s", c.Plugins[1].Endpoint)
+	}
+	if len(c.RepoPlugins["Org2"]) != 1 || len(c.RepoPlugins["org"]) != 1 {
+		t.Errorf("expected Org2 to be added in its case, got %v", c.RepoPlugins)
+	}
+	if len(c.RepoPlugins["Org/Repo-A"]) != 1 {
+		t.Errorf("expected Org/Repo-A to be added, got %v", c.RepoPlugins)
+	}
+
+	t.Setenv("TEST_CONFIG_PLUGINS__B__ENDPOINT", "http://b")
+	if err := ca.load(WithEnvOverlay(NewFileSource(path), "TEST_CONFIG_")); err == nil {
+		t.Error("expected error when overriding an item which doesn't exist")
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// UnknownFieldsReject makes loading fail when config has unknown or duplicate fields.
+	UnknownFieldsReject = "reject"
+
+	// UnknownFieldsWarn logs unknown or duplicate fields and ignores them.
+	UnknownFieldsWarn = "warn"
+)
+
+// ValidateUnknownFieldsMode checks that mode is one of UnknownFieldsReject and UnknownFieldsWarn.
+func ValidateUnknownFieldsMode(mode string) error {
+	if mode != UnknownFieldsReject && mode != UnknownFieldsWarn {
+		return fmt.Errorf("unknown mode of unknown fields: %s", mode)
+	}
+
+	return nil
+}
+
+// SetUnknownFieldsMode sets how the unknown fields of config are handled,
+// it is UnknownFieldsReject by default.
+func (ca *ConfigAgent) SetUnknownFieldsMode(mode string) error {
+	if err := ValidateUnknownFieldsMode(mode); err != nil {
+		return err
+	}
+
+	ca.switchMut.Lock()
+	ca.unknownFields = mode
+	ca.switchMut.Unlock()
+
+	return nil
+}
+
+// unmarshal decodes content into c in the mode of unknown fields.
+func unmarshal(content []byte, c Config, mode string, l *logrus.Entry) error {
+	err := yaml.UnmarshalStrict(content, c)
+	if err == nil || mode == UnknownFieldsReject {
+		return err
+	}
+
+	// it may fail for another reason than unknown fields, which the
+	// non-strict decoding will tell
+	if err2 := yaml.Unmarshal(content, c); err2 != nil {
+		return err2
+	}
+
+	l.WithError(err).Warn("config has unknown or duplicate fields, they are ignored")
+
+	return nil
+}
+
+// Parse reads the config from src into c and sets its default values,
+// without validating it. It is meant for tools checking configs offline.
+func Parse(src ConfigSource, c Config, unknownFieldsMode string) error {
+	if err := ValidateUnknownFieldsMode(unknownFieldsMode); err != nil {
+		return err
+	}
+
+	content, err := src.Read()
+	if err != nil {
+		return err
+	}
+
+	if err := unmarshal(content, c, unknownFieldsMode, logrus.WithField("source", src.String())); err != nil {
+		return err
+	}
+
+	c.SetDefault()
+
+	return nil
+}
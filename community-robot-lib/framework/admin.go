@@ -0,0 +1,94 @@
+package framework
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+	"community-robot-lib/options"
+)
+
+const (
+	AdminConfigPath = "/admin/config/"
+	HealthPath      = "/healthz"
+	MetricsPath     = "/debug/vars"
+
+	bearerPrefix = "Bearer "
+)
+
+// newAdminMux returns the mux of the admin listener, it is kept apart from
+// the one which the webhooks are served on, so that none of these endpoints
+// is reachable where the webhooks are. Without the admin listener, they are
+// mounted on public, the mux of the webhooks, as they were before it existed.
+func newAdminMux(public *http.ServeMux, agent *config.ConfigAgent, metrics *eventMetrics, servOpt options.ServiceOptions) *http.ServeMux {
+	mux := public
+	if servOpt.AdminPort != 0 {
+		mux = http.NewServeMux()
+	}
+
+	mux.HandleFunc(HealthPath, func(w http.ResponseWriter, r *http.Request) {
+		// service's healthy check, do nothing
+	})
+
+	mux.Handle(MetricsPath, metrics)
+
+	if servOpt.AdminTokenGenerator != nil {
+		mux.Handle(AdminConfigPath, requireAdmin(
+			http.StripPrefix(strings.TrimSuffix(AdminConfigPath, "/"), agent.AdminHandler()), servOpt,
+		))
+	} else {
+		logrus.Info("no admin token, the admin endpoints are disabled")
+	}
+
+	return mux
+}
+
+// requireAdmin only lets the requests carrying the admin token, and the client
+// certificate if it is required, reach h. The webhook secret is never accepted,
+// since every one who configures the webhooks holds it.
+func requireAdmin(h http.Handler, servOpt options.ServiceOptions) http.Handler {
+	return RequireClientCert(requireToken(h, servOpt.AdminTokenGenerator), servOpt)
+}
+
+// requireToken only lets the requests carrying the token as a bearer token
+// in the Authorization header reach h. Every request is rejected if there is no token.
+func requireToken(h http.Handler, token func() []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get("Authorization")
+
+		var expected []byte
+		if token != nil {
+			expected = token()
+		}
+
+		if len(expected) == 0 || !strings.HasPrefix(v, bearerPrefix) ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(v, bearerPrefix)), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RequireClientCert only lets the requests with a verified client certificate
+// reach h when servOpt has a client CA, otherwise h is returned as it is.
+// The webhooks can't carry client certificates, so it is applied to the
+// endpoints called by plugins and admins only.
+func RequireClientCert(h http.Handler, servOpt options.ServiceOptions) http.Handler {
+	if servOpt.TLSClientCAFile == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
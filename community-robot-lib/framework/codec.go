@@ -0,0 +1,264 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"community-robot-lib/framework/eventpb"
+)
+
+// EventSchemaVersion is the version of eventpb the events are encoded with.
+// It is bumped only when the meaning of the fields changes, adding a field
+// keeps the version, since the decoders ignore the fields they don't know.
+const EventSchemaVersion = 1
+
+// The formats an event can be encoded with, see GenericEvent.Encode.
+const (
+	// EventFormatProto is the eventpb.Envelope prefixed by eventMagic.
+	EventFormatProto = "proto"
+
+	// EventFormatGob is the gob of GenericEvent, which is what the robots built
+	// with the libs before EventSchemaVersion understand. It is readable only
+	// while the fields of GenericEvent are not renamed, so it is kept for the
+	// migration only.
+	EventFormatGob = "gob"
+)
+
+// eventMagic marks the events of EventFormatProto. A gob stream never starts
+// with 0, which is the length of its first message, so they can't be confused.
+var eventMagic = []byte("\x00rbev")
+
+// ValidEventFormat returns whether format is one of EventFormat*.
+func ValidEventFormat(format string) bool {
+	return format == EventFormatProto || format == EventFormatGob
+}
+
+// ConvertToBytes encodes the event with EventFormatGob, which every robot
+// understands. Use Encode to send EventFormatProto to the robots which opt in.
+func (ge *GenericEvent) ConvertToBytes() ([]byte, error) {
+	return ge.Encode(EventFormatGob)
+}
+
+// Encode encodes the event with format, which is one of EventFormat*.
+func (ge *GenericEvent) Encode(format string) ([]byte, error) {
+	switch format {
+	case EventFormatProto:
+		b, err := proto.Marshal(&eventpb.Envelope{
+			SchemaVersion: EventSchemaVersion,
+			Event:         eventToProto(ge),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return append(append(make([]byte, 0, len(eventMagic)+len(b)), eventMagic...), b...), nil
+
+	case EventFormatGob:
+		buf := new(bytes.Buffer)
+		if err := gob.NewEncoder(buf).Encode(ge); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+
+	default:
+		return nil, fmt.Errorf("unknown event format: %s", format)
+	}
+}
+
+// ConvertFromBytes decodes the event encoded with any of EventFormat*.
+func (ge *GenericEvent) ConvertFromBytes(b []byte) error {
+	if b == nil {
+		return errors.New("no data to convert")
+	}
+
+	if !bytes.HasPrefix(b, eventMagic) {
+		return gob.NewDecoder(bytes.NewReader(b)).Decode(ge)
+	}
+
+	v := new(eventpb.Envelope)
+	if err := proto.Unmarshal(b[len(eventMagic):], v); err != nil {
+		return err
+	}
+
+	switch n := v.GetSchemaVersion(); {
+	case n == 0:
+		return errors.New("missing schema version of event")
+	case n > EventSchemaVersion:
+		return fmt.Errorf(
+			"schema version %d of event is newer than %d, the lib should be upgraded",
+			n, EventSchemaVersion,
+		)
+	}
+
+	*ge = *eventFromProto(v.GetEvent())
+
+	return nil
+}
+
+func eventToProto(ge *GenericEvent) *eventpb.Event {
+	commits := make([]*eventpb.Commit, 0, len(ge.Commits))
+	for i := range ge.Commits {
+		c := &ge.Commits[i]
+		commits = append(commits, &eventpb.Commit{
+			Sha:       c.SHA,
+			Message:   c.Message,
+			Author:    userToProto(c.Author),
+			Timestamp: timeToProto(c.Timestamp),
+			Added:     c.Added,
+			Modified:  c.Modified,
+			Removed:   c.Removed,
+		})
+	}
+
+	return &eventpb.Event{
+		EventType:       int32(ge.EventType),
+		PlatformName:    ge.PlatformName,
+		EventName:       ge.EventName,
+		EventUuid:       ge.EventUUID,
+		SupersededCount: int32(ge.SupersededCount),
+		Sender:          userToProto(ge.Sender),
+		CreatedAt:       timeToProto(ge.CreatedAt),
+		UpdatedAt:       timeToProto(ge.UpdatedAt),
+
+		Action:  ge.Action,
+		Org:     ge.Org,
+		Repo:    ge.Repo,
+		HtmlUrl: ge.HtmlURL,
+		Push: &eventpb.Push{
+			Base:    ge.Base,
+			Head:    ge.Head,
+			Ref:     ge.Ref,
+			Commits: commits,
+		},
+		Issue: &eventpb.Issue{
+			Number:    ge.IssueNumber,
+			Author:    ge.IssueAuthor,
+			Comment:   ge.IssueComment,
+			Commenter: ge.IssueCommenter,
+			Labels:    ge.IssuePayload.IssueLabels,
+		},
+		PullRequest: &eventpb.PullRequest{
+			Number:      ge.PRNumber,
+			Author:      ge.PRAuthor,
+			Comment:     ge.PRComment,
+			Commenter:   ge.PRCommenter,
+			Labels:      ge.PullRequestPayload.IssueLabels,
+			BaseRef:     ge.BaseRef,
+			BaseSha:     ge.BaseSHA,
+			HeadRef:     ge.HeadRef,
+			HeadSha:     ge.HeadSHA,
+			MergeState:  ge.MergeState,
+			ReviewState: ge.ReviewState,
+		},
+		Assignees:     ge.Assignees,
+		Milestone:     ge.Milestone,
+		LabelsAdded:   ge.LabelsAdded,
+		LabelsRemoved: ge.LabelsRemoved,
+
+		SourcePayload: ge.SourcePayload,
+	}
+}
+
+func eventFromProto(v *eventpb.Event) *GenericEvent {
+	var commits []Commit
+	for _, c := range v.GetPush().GetCommits() {
+		commits = append(commits, Commit{
+			SHA:       c.GetSha(),
+			Message:   c.GetMessage(),
+			Author:    userFromProto(c.GetAuthor()),
+			Timestamp: timeFromProto(c.GetTimestamp()),
+			Added:     c.GetAdded(),
+			Modified:  c.GetModified(),
+			Removed:   c.GetRemoved(),
+		})
+	}
+
+	push, issue, pr := v.GetPush(), v.GetIssue(), v.GetPullRequest()
+
+	return &GenericEvent{
+		EventHeader: EventHeader{
+			EventType:       int(v.GetEventType()),
+			PlatformName:    v.GetPlatformName(),
+			EventName:       v.GetEventName(),
+			EventUUID:       v.GetEventUuid(),
+			SupersededCount: int(v.GetSupersededCount()),
+			Sender:          userFromProto(v.GetSender()),
+			CreatedAt:       timeFromProto(v.GetCreatedAt()),
+			UpdatedAt:       timeFromProto(v.GetUpdatedAt()),
+		},
+		EventPayload: EventPayload{
+			Action:  v.GetAction(),
+			Org:     v.GetOrg(),
+			Repo:    v.GetRepo(),
+			HtmlURL: v.GetHtmlUrl(),
+			PushPayload: PushPayload{
+				Base:    push.GetBase(),
+				Head:    push.GetHead(),
+				Ref:     push.GetRef(),
+				Commits: commits,
+			},
+			IssuePayload: IssuePayload{
+				IssueNumber:    issue.GetNumber(),
+				IssueAuthor:    issue.GetAuthor(),
+				IssueComment:   issue.GetComment(),
+				IssueCommenter: issue.GetCommenter(),
+				IssueLabels:    issue.GetLabels(),
+			},
+			PullRequestPayload: PullRequestPayload{
+				PRNumber:    pr.GetNumber(),
+				PRAuthor:    pr.GetAuthor(),
+				PRComment:   pr.GetComment(),
+				PRCommenter: pr.GetCommenter(),
+				IssueLabels: pr.GetLabels(),
+				BaseRef:     pr.GetBaseRef(),
+				BaseSHA:     pr.GetBaseSha(),
+				HeadRef:     pr.GetHeadRef(),
+				HeadSHA:     pr.GetHeadSha(),
+				MergeState:  pr.GetMergeState(),
+				ReviewState: pr.GetReviewState(),
+			},
+			Assignees:     v.GetAssignees(),
+			Milestone:     v.GetMilestone(),
+			LabelsAdded:   v.GetLabelsAdded(),
+			LabelsRemoved: v.GetLabelsRemoved(),
+		},
+		SourcePayload: v.GetSourcePayload(),
+	}
+}
+
+func userToProto(u User) *eventpb.User {
+	if u == (User{}) {
+		return nil
+	}
+
+	return &eventpb.User{Login: u.Login, Name: u.Name, Email: u.Email}
+}
+
+func userFromProto(u *eventpb.User) User {
+	return User{Login: u.GetLogin(), Name: u.GetName(), Email: u.GetEmail()}
+}
+
+// timeToProto keeps the zero time unset. The location of t is not kept,
+// the time is decoded in UTC.
+func timeToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+func timeFromProto(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return t.AsTime()
+}
@@ -0,0 +1,285 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/gob"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"community-robot-lib/framework/eventpb"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files of testdata.")
+
+// codecEvent sets every field of GenericEvent, so that the tests notice
+// a field which is not converted.
+func codecEvent() *GenericEvent {
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	return &GenericEvent{
+		EventHeader: EventHeader{
+			EventType:       PullRequestEvent,
+			PlatformName:    "gitee",
+			EventName:       "Merge Request Hook",
+			EventUUID:       "uuid",
+			SupersededCount: 2,
+			Sender:          User{Login: "alice", Name: "Alice", Email: "alice@example.com"},
+			CreatedAt:       now,
+			UpdatedAt:       now.Add(time.Hour),
+		},
+		EventPayload: EventPayload{
+			Action:  "update",
+			Org:     "org",
+			Repo:    "repo",
+			HtmlURL: "https://gitee.com/org/repo/pulls/1",
+			PushPayload: PushPayload{
+				Base: "a",
+				Head: "b",
+				Ref:  "refs/heads/master",
+				Commits: []Commit{
+					{
+						SHA:       "b",
+						Message:   "fix",
+						Author:    User{Login: "bob"},
+						Timestamp: now,
+						Added:     []string{"b.go"},
+						Modified:  []string{"a.go"},
+						Removed:   []string{"c.go"},
+					},
+				},
+			},
+			IssuePayload: IssuePayload{
+				IssueNumber:    "2",
+				IssueAuthor:    "carol",
+				IssueComment:   "issue comment",
+				IssueCommenter: "dave",
+				IssueLabels:    []string{"bug"},
+			},
+			PullRequestPayload: PullRequestPayload{
+				PRNumber:    "1",
+				PRAuthor:    "alice",
+				PRComment:   "/lgtm",
+				PRCommenter: "bob",
+				IssueLabels: []string{"lgtm"},
+				BaseRef:     "master",
+				BaseSHA:     "a",
+				HeadRef:     "feature",
+				HeadSHA:     "b",
+				MergeState:  MergeStateMergeable,
+				ReviewState: ReviewStateApproved,
+			},
+			Assignees:     []string{"bob"},
+			Milestone:     "v1",
+			LabelsAdded:   []string{"lgtm"},
+			LabelsRemoved: []string{"wip"},
+		},
+		SourcePayload: []byte(`{"action":"update"}`),
+	}
+}
+
+func decodeEvent(t *testing.T, b []byte) *GenericEvent {
+	t.Helper()
+
+	evt := new(GenericEvent)
+	if err := evt.ConvertFromBytes(b); err != nil {
+		t.Fatal(err)
+	}
+
+	return evt
+}
+
+func TestEncodeEvent(t *testing.T) {
+	evt := codecEvent()
+
+	for _, format := range []string{EventFormatProto, EventFormatGob} {
+		b, err := evt.Encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := decodeEvent(t, b); !reflect.DeepEqual(got, evt) {
+			t.Errorf("%s: expected %+v, got %+v", format, evt, got)
+		}
+	}
+
+	if _, err := evt.Encode("json"); err == nil {
+		t.Error("expected an error of unknown format")
+	}
+}
+
+func TestEncodeEmptyEvent(t *testing.T) {
+	for _, format := range []string{EventFormatProto, EventFormatGob} {
+		b, err := new(GenericEvent).Encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := decodeEvent(t, b); !reflect.DeepEqual(got, new(GenericEvent)) {
+			t.Errorf("%s: expected an empty event, got %+v", format, got)
+		}
+	}
+}
+
+func TestConvertToBytesIsGob(t *testing.T) {
+	b, err := codecEvent().ConvertToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the robots built with the libs before EventSchemaVersion decode it by plain gob
+	var v legacyEvent
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v); err != nil {
+		t.Fatalf("expected gob, got %v", err)
+	}
+
+	if v.EventPayload.Org != codecEvent().Org {
+		t.Errorf("expected org %s, got %s", codecEvent().Org, v.EventPayload.Org)
+	}
+}
+
+// TestDecodeGoldenEvent keeps the events encoded by the former versions of
+// the lib readable. Run the test with -update after adding a field to write
+// the new golden file, but never change the ones of the former versions.
+func TestDecodeGoldenEvent(t *testing.T) {
+	path := filepath.Join("testdata", "event_v1.bin")
+
+	if *updateGolden {
+		b, err := codecEvent().Encode(EventFormatProto)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := decodeEvent(t, b); !reflect.DeepEqual(got, codecEvent()) {
+		t.Errorf("expected %+v, got %+v", codecEvent(), got)
+	}
+}
+
+// The GenericEvent of the libs before EventSchemaVersion, which sent it by gob.
+// Gob names an embedded struct after its type, so the embedded structs of it are
+// the fields of the same names here.
+type legacyEvent struct {
+	EventHeader struct {
+		EventType    int
+		PlatformName string
+		EventName    string
+		EventUUID    string
+	}
+	EventPayload struct {
+		Action       string
+		Org          string
+		Repo         string
+		HtmlURL      string
+		PushPayload  struct{ Base, Head string }
+		IssuePayload struct {
+			IssueNumber    string
+			IssueAuthor    string
+			IssueComment   string
+			IssueCommenter string
+			IssueLabels    []string
+		}
+		PullRequestPayload struct {
+			PRNumber    string
+			PRAuthor    string
+			PRComment   string
+			PRCommenter string
+			IssueLabels []string
+		}
+	}
+	SourcePayload []byte
+}
+
+func TestDecodeLegacyGob(t *testing.T) {
+	var v legacyEvent
+	v.EventHeader.EventType = IssueCommentEvent
+	v.EventHeader.EventUUID = "uuid"
+	v.EventPayload.Org = "org"
+	v.EventPayload.Repo = "repo"
+	v.EventPayload.PushPayload.Head = "b"
+	v.EventPayload.IssuePayload.IssueNumber = "2"
+	v.EventPayload.PullRequestPayload.IssueLabels = []string{"lgtm"}
+	v.SourcePayload = []byte("{}")
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	got := decodeEvent(t, buf.Bytes())
+
+	want := new(GenericEvent)
+	want.EventType = IssueCommentEvent
+	want.EventUUID = "uuid"
+	want.Org = "org"
+	want.Repo = "repo"
+	want.Head = "b"
+	want.IssueNumber = "2"
+	want.PullRequestPayload.IssueLabels = []string{"lgtm"}
+	want.SourcePayload = []byte("{}")
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+// envelope encodes the event with the fields unknown to this version appended.
+func envelope(t *testing.T, version uint64, evt *eventpb.Event) []byte {
+	b, err := proto.Marshal(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = protowire.AppendTag(b, 99, protowire.BytesType)
+	b = protowire.AppendString(b, "added by a newer version")
+
+	var env []byte
+	if version > 0 {
+		env = protowire.AppendTag(env, 1, protowire.VarintType)
+		env = protowire.AppendVarint(env, version)
+	}
+	env = protowire.AppendTag(env, 2, protowire.BytesType)
+	env = protowire.AppendBytes(env, b)
+	env = protowire.AppendTag(env, 15, protowire.VarintType)
+	env = protowire.AppendVarint(env, 1)
+
+	return append(append([]byte{}, eventMagic...), env...)
+}
+
+func TestDecodeUnknownFields(t *testing.T) {
+	evt := codecEvent()
+
+	got := decodeEvent(t, envelope(t, EventSchemaVersion, eventToProto(evt)))
+	if !reflect.DeepEqual(got, evt) {
+		t.Errorf("expected %+v, got %+v", evt, got)
+	}
+}
+
+func TestDecodeSchemaVersion(t *testing.T) {
+	cases := map[string]uint64{
+		"missing": 0,
+		"newer":   EventSchemaVersion + 1,
+	}
+
+	for name, version := range cases {
+		err := new(GenericEvent).ConvertFromBytes(envelope(t, version, eventToProto(codecEvent())))
+		if err == nil || !strings.Contains(err.Error(), "schema version") {
+			t.Errorf("%s: expected an error of schema version, got %v", name, err)
+		}
+	}
+}
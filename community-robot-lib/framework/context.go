@@ -0,0 +1,59 @@
+package framework
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+)
+
+// ContextHandlerFunc is a handler which is told by ctx that its time budget
+// ran out or that the robot is shutting down, see RegisterContextHandler.
+type ContextHandlerFunc func(ctx context.Context, evt *GenericEvent, cnf config.Config, lgr *logrus.Entry) error
+
+// AdaptHandler turns a GenericHandlerFunc into a ContextHandlerFunc which ignores ctx.
+func AdaptHandler(fn GenericHandlerFunc) ContextHandlerFunc {
+	if fn == nil {
+		return nil
+	}
+
+	return func(_ context.Context, evt *GenericEvent, cnf config.Config, lgr *logrus.Entry) error {
+		return fn(evt, cnf, lgr)
+	}
+}
+
+// HandlerTimeoutConfig is implemented by the configs of the robots which set the
+// time budget of handling each event type. A timeout which is not positive falls
+// back to ServiceOptions.HandlerTimeout.
+type HandlerTimeoutConfig interface {
+	HandlerTimeout(eventType int) time.Duration
+}
+
+// handlerContext returns the context which the handlers of an event of eventType are called with.
+func (d *dispatcher) handlerContext(eventType int, cnf config.Config) (context.Context, context.CancelFunc) {
+	return newHandlerContext(d.ctx, d.timeout, eventType, cnf)
+}
+
+// newHandlerContext derives the context of the handlers from ctx, the timeout
+// of cnf, if it is a HandlerTimeoutConfig, takes precedence over timeout.
+func newHandlerContext(
+	ctx context.Context, timeout time.Duration, eventType int, cnf config.Config,
+) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c, ok := cnf.(HandlerTimeoutConfig); ok {
+		if v := c.HandlerTimeout(eventType); v > 0 {
+			timeout = v
+		}
+	}
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
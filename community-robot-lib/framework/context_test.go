@@ -0,0 +1,73 @@
+package framework
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+)
+
+type timeoutConfig struct{}
+
+func (c *timeoutConfig) Validate() error { return nil }
+func (c *timeoutConfig) SetDefault()     {}
+
+func (c *timeoutConfig) HandlerTimeout(eventType int) time.Duration {
+	if eventType == IssueEvent {
+		return 10 * time.Millisecond
+	}
+
+	return 0
+}
+
+func TestHandlerContext(t *testing.T) {
+	shutdown, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	d := &dispatcher{ctx: shutdown, timeout: time.Hour}
+
+	deadline := func(eventType int) time.Duration {
+		ctx, cancel := d.handlerContext(eventType, &timeoutConfig{})
+		defer cancel()
+
+		v, ok := ctx.Deadline()
+		if !ok {
+			t.Fatalf("expected a deadline of event type %d", eventType)
+		}
+
+		return time.Until(v)
+	}
+
+	if v := deadline(IssueEvent); v > 10*time.Millisecond {
+		t.Errorf("expected the timeout of config, got %s", v)
+	}
+	if v := deadline(PushEvent); v < time.Minute {
+		t.Errorf("expected the default timeout, got %s", v)
+	}
+
+	// the old handlers still work, and the new ones see the shutdown
+	h := handlers{}
+	h.RegisterIssueHandler(func(*GenericEvent, config.Config, *logrus.Entry) error {
+		return nil
+	})
+	h.RegisterContextHandler(IssueEvent, func(ctx context.Context, _ *GenericEvent, _ config.Config, _ *logrus.Entry) error {
+		stop()
+		<-ctx.Done()
+
+		return ctx.Err()
+	})
+
+	d.handlers = h.index()
+	d.metrics = newEventMetrics()
+	d.agent = new(config.ConfigAgent)
+
+	d.wg.Add(1)
+	d.handleEvent(&GenericEvent{EventHeader: EventHeader{EventType: IssueEvent}}, logrus.NewEntry(logrus.New()))
+
+	if d.metrics.eventsFailed.Value() != 1 {
+		t.Error("expected the context handler to fail with the cancelled context")
+	}
+}
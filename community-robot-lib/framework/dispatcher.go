@@ -1,10 +1,16 @@
 package framework
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"reflect"
+	"runtime"
 	"sync"
+	"time"
 
 	"community-robot-lib/config"
+	"community-robot-lib/utils"
 
 	"github.com/sirupsen/logrus"
 )
@@ -18,9 +24,38 @@ type dispatcher struct {
 
 	h handlers
 
+	// handlers are indexed by Event-Type Value
+	handlers map[int]*eventHandlers
+
+	// parallel makes the handlers of an event run concurrently
+	// instead of one after another in the order they are registered
+	parallel bool
+
+	// ctx is cancelled when the robot starts shutting down
+	ctx context.Context
+
+	// quarantine keeps the events which made the handlers panic repeatedly
+	quarantine *quarantine
+
+	// timeout is the time budget of handling an event, see HandlerTimeoutConfig
+	timeout time.Duration
+
+	metrics *eventMetrics
+
 	// Tracks running handlers for graceful shutdown
 	wg sync.WaitGroup
 
+	// pool handles the events by a fixed number of workers, every
+	// event is handled by a goroutine of its own if it is nil
+	pool *workerPool
+
+	// spill keeps the events which overflow the queue
+	spill *spillStore
+
+	// serial is not nil when the events of the same PR or issue
+	// must be handled one after another in the order they come.
+	serial *utils.KeyedExecutor
+
 	// secret usage
 	hmac func() []byte
 }
@@ -33,51 +68,189 @@ func (d *dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	lgr := logrus.WithFields(ge.CollectLogFiled())
 
-	d.Dispatch(ge, lgr)
+	if err := d.Dispatch(ge, lgr); errors.Is(err, errQueueFull) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
-func (d *dispatcher) Dispatch(event *GenericEvent, lgr *logrus.Entry) {
-	if event.EventType < AccessEvent || event.EventType > OtherEvent {
+// Dispatch queues the event to be handled. If the queue is full, the event is
+// either spilled to disk or rejected with errQueueFull, see ServiceOptions.QueueFullPolicy.
+func (d *dispatcher) Dispatch(event *GenericEvent, lgr *logrus.Entry) error {
+	d.metrics.eventsReceived.Add(1)
+
+	if !KnownEventType(event.EventType) {
+		d.metrics.eventsIgnored.Add(1)
 		lgr.Error("Ignoring unknown event type")
-	} else {
-		d.wg.Add(1)
-		go d.handleEvent(event, lgr)
+
+		return nil
+	}
+
+	if d.quarantine.enabled() && d.quarantine.has(eventID(event)) {
+		d.metrics.eventsIgnored.Add(1)
+		lgr.Warn("Ignoring quarantined event")
+
+		return nil
 	}
+
+	if d.pool != nil && !d.pool.reserve() {
+		if d.spill == nil {
+			d.metrics.eventsRejected.Add(1)
+			lgr.Warn("Rejecting event, the queue is full")
+
+			return errQueueFull
+		}
+
+		if err := d.spill.save(event); err != nil {
+			d.metrics.eventsRejected.Add(1)
+			lgr.WithError(err).Error("Error spilling event, it is rejected.")
+
+			return errQueueFull
+		}
+
+		d.metrics.eventsSpilled.Add(1)
+		lgr.Info("The queue is full, the event is spilled to disk")
+
+		return nil
+	}
+
+	d.enqueue(event, lgr)
+
+	return nil
+}
+
+// enqueue hands the event to the pool, a place in the queue must have been
+// reserved for it. The events of the same PR or issue keep their order, since
+// they are queued one after another by the serial executor.
+func (d *dispatcher) enqueue(event *GenericEvent, lgr *logrus.Entry) {
+	d.wg.Add(1)
+	d.metrics.eventsRunning.Add(1)
+
+	handle := func() { d.handleEvent(event, lgr) }
+	key := event.OrderingKey()
+
+	if d.pool == nil {
+		if d.serial != nil && key != "" {
+			d.serial.Run(key, handle)
+		} else {
+			go handle()
+		}
+
+		return
+	}
+
+	job := poolJob{run: handle, queuedAt: time.Now()}
+
+	if d.serial == nil || key == "" {
+		d.pool.submit(job)
+
+		return
+	}
+
+	d.serial.Run(key, func() {
+		done := make(chan struct{})
+		d.pool.submit(poolJob{
+			run: func() {
+				defer close(done)
+				handle()
+			},
+			queuedAt: job.queuedAt,
+		})
+		<-done
+	})
 }
 
 func (d *dispatcher) Wait() {
 	d.wg.Wait() // Handle remaining requests
 }
 
-var eventHandlerList []GenericHandlerFunc
-var once sync.Once
+// namedHandler is a handler with the name which its errors are reported with.
+type namedHandler struct {
+	name string
+	fn   ContextHandlerFunc
+}
 
-// Event-Type Value
-const (
-	AccessEvent = iota
-	PushEvent
-	IssueEvent
-	PullRequestEvent
-	IssueCommentEvent
-	PullRequestCommentEvent
-	OtherEvent
-)
+func newNamedHandler(fn GenericHandlerFunc) namedHandler {
+	return namedHandler{name: handlerName(fn), fn: AdaptHandler(fn)}
+}
+
+// eventHandlers are the handlers of an event type and the middlewares
+// which are layered around the handling of an event as a whole.
+type eventHandlers struct {
+	list        []namedHandler
+	middlewares []Middleware
+}
+
+// index returns the handlers of each event type with the middlewares of it.
+func (h *handlers) index() map[int]*eventHandlers {
+	ans := make(map[int]*eventHandlers, len(h.byType))
+	for t, hs := range h.byType {
+		for _, nh := range hs {
+			if nh.fn == nil {
+				continue
+			}
+
+			if ans[t] == nil {
+				ans[t] = &eventHandlers{middlewares: h.middlewaresOf(t)}
+			}
+			ans[t].list = append(ans[t].list, nh)
+		}
+	}
+
+	return ans
+}
+
+// run calls every handler of the event even if one of them fails, either one after
+// another or concurrently if parallel is true. The middlewares are called once around
+// all of the handlers. It returns the error of each handler, and the error which
+// stopped the event before the handlers, such as the one of a Pre, as rejected.
+func (e *eventHandlers) run(
+	ctx context.Context, evt *GenericEvent, cnf config.Config, lgr *logrus.Entry, parallel bool,
+) (errs []error, rejected error) {
+	errs = make([]error, len(e.list))
+	called := false
+
+	fn := func(ctx context.Context, evt *GenericEvent, cnf config.Config, lgr *logrus.Entry) error {
+		called = true
 
-func (h *handlers) indexEventHandler() {
-	// slice element order must same to Event-Type Value
-	eventHandlerList = []GenericHandlerFunc{
-		h.accessHandler,
-		h.pushCodeBranchTagHandler,
-		h.issueHandlers,
-		h.pullRequestHandler,
-		h.issueCommentHandler,
-		h.pullRequestCommentHandler,
-		h.otherHandler,
+		if parallel && len(e.list) > 1 {
+			var wg sync.WaitGroup
+			for i := range e.list {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = callHandler(func() error { return e.list[i].fn(ctx, evt, cnf, lgr) })
+				}(i)
+			}
+			wg.Wait()
+		} else {
+			for i := range e.list {
+				errs[i] = callHandler(func() error { return e.list[i].fn(ctx, evt, cnf, lgr) })
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+
+	for i := len(e.middlewares) - 1; i >= 0; i-- {
+		fn = e.middlewares[i].Wrap(fn)
+	}
+
+	if err := callHandler(func() error { return fn(ctx, evt, cnf, lgr) }); !called {
+		rejected = err
 	}
+
+	return errs, rejected
 }
 
-func buildDispatcherHandler(h *handlers) {
-	once.Do(h.indexEventHandler)
+func handlerName(fn interface{}) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		return f.Name()
+	}
+
+	return "unknown"
 }
 
 func (d *dispatcher) getConfig() config.Config {
@@ -86,14 +259,72 @@ func (d *dispatcher) getConfig() config.Config {
 	return c
 }
 
-// handleAccessEvent access robot handle request that come form webhook
+// handleEvent calls the handlers of the event within the middlewares, the failure
+// of one handler doesn't stop the others and is reported on its own.
 func (d *dispatcher) handleEvent(evt *GenericEvent, lgr *logrus.Entry) {
 	defer d.wg.Done()
+	defer d.metrics.eventsRunning.Add(-1)
+
+	hs := d.handlers[evt.EventType]
+	if hs == nil {
+		d.metrics.eventsIgnored.Add(1)
+		lgr.Debug("Ignoring event without handler")
+
+		return
+	}
+
+	cnf := d.getConfig()
+
+	ctx, cancel := d.handlerContext(evt.EventType, cnf)
+	defer cancel()
+
+	errs, rejected := hs.run(ctx, evt, cnf, lgr, d.parallel)
+
+	failed := false
+	var panicked *panicError
+	report := func(l *logrus.Entry, err error) {
+		failed = true
+
+		if pe, ok := err.(*panicError); ok {
+			panicked = pe
+			l.WithField("stack", string(pe.stack)).Error(err)
+		} else {
+			l.Error(err)
+		}
+	}
+
+	if rejected != nil {
+		report(lgr.WithField("handler", "middleware"), rejected)
+	}
 
-	fn := eventHandlerList[evt.EventType]
-	if err := fn(evt, d.getConfig(), lgr); err != nil {
-		lgr.Error(err)
+	for i, err := range errs {
+		if err != nil {
+			report(lgr.WithField("handler", hs.list[i].name), err)
+		}
+	}
+
+	if panicked != nil {
+		d.metrics.eventsPanicked.Add(1)
+		d.recordPanic(evt, panicked, lgr)
+	}
+
+	if failed {
+		d.metrics.eventsFailed.Add(1)
 	} else {
+		d.metrics.eventsHandled.Add(1)
 		lgr.Info()
 	}
 }
+
+func (d *dispatcher) recordPanic(evt *GenericEvent, e *panicError, lgr *logrus.Entry) {
+	quarantined, err := d.quarantine.recordPanic(evt, e)
+	if err != nil {
+		lgr.WithError(err).Error("Error quarantining event.")
+		return
+	}
+
+	if quarantined {
+		d.metrics.eventsQuarantined.Add(1)
+		lgr.WithField("event-id", eventID(evt)).Warn("Event panicked repeatedly, it is quarantined.")
+	}
+}
@@ -0,0 +1,103 @@
+package framework
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+)
+
+func TestHandlersOfSameEventType(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		var mut sync.Mutex
+		var calls []string
+
+		handler := func(name string, err error) GenericHandlerFunc {
+			return func(*GenericEvent, config.Config, *logrus.Entry) error {
+				mut.Lock()
+				calls = append(calls, name)
+				mut.Unlock()
+
+				return err
+			}
+		}
+
+		h := handlers{}
+		h.RegisterPullRequestHandler(handler("first", errors.New("first failed")))
+		h.RegisterPullRequestHandler(handler("second", nil))
+		h.RegisterPullRequestHandler(handler("third", errors.New("third failed")))
+
+		d := &dispatcher{
+			agent:    new(config.ConfigAgent),
+			handlers: h.index(),
+			parallel: parallel,
+			metrics:  newEventMetrics(),
+		}
+
+		d.wg.Add(1)
+		d.handleEvent(&GenericEvent{EventHeader: EventHeader{EventType: PullRequestEvent}}, logrus.NewEntry(logrus.New()))
+
+		if len(calls) != 3 {
+			t.Fatalf("parallel=%v: expected all the handlers to be called, got %v", parallel, calls)
+		}
+		if !parallel && (calls[0] != "first" || calls[1] != "second" || calls[2] != "third") {
+			t.Errorf("expected the handlers to be called in order, got %v", calls)
+		}
+		if v := d.metrics.eventsFailed.Value(); v != 1 {
+			t.Errorf("parallel=%v: expected the event to be failed once, got %d", parallel, v)
+		}
+	}
+}
+
+func TestNewEventTypes(t *testing.T) {
+	var got []int
+
+	h := handlers{}
+	for _, r := range []func(GenericHandlerFunc){
+		h.RegisterReleaseHandler,
+		h.RegisterTagHandler,
+		h.RegisterPullRequestReviewHandler,
+		h.RegisterPipelineHandler,
+		h.RegisterMemberHandler,
+		h.RegisterRepoSettingsHandler,
+	} {
+		r(func(evt *GenericEvent, _ config.Config, _ *logrus.Entry) error {
+			got = append(got, evt.EventType)
+			return nil
+		})
+	}
+	// unknown types are ignored
+	h.RegisterHandler(1000, func(*GenericEvent, config.Config, *logrus.Entry) error { return nil })
+
+	d := &dispatcher{
+		agent:    new(config.ConfigAgent),
+		handlers: h.index(),
+		metrics:  newEventMetrics(),
+	}
+
+	if _, ok := d.handlers[1000]; ok {
+		t.Fatal("expected the handler of unknown type to be ignored")
+	}
+
+	types := []int{ReleaseEvent, TagEvent, PullRequestReviewEvent, PipelineEvent, MemberEvent, RepoSettingsEvent}
+	for _, et := range types {
+		if EventTypeName(et) == "" {
+			t.Errorf("event type %d has no name", et)
+		}
+
+		d.wg.Add(1)
+		d.handleEvent(&GenericEvent{EventHeader: EventHeader{EventType: et}}, logrus.NewEntry(logrus.New()))
+	}
+
+	if len(got) != len(types) {
+		t.Fatalf("expected every new event type to be handled, got %v", got)
+	}
+	for i := range types {
+		if got[i] != types[i] {
+			t.Errorf("expected event type %d, got %d", types[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package framework
+
+// Event-Type Value, the values are kept when new types are added,
+// since they are carried by the events delivered to the plugins.
+const (
+	AccessEvent = iota
+	PushEvent
+	IssueEvent
+	PullRequestEvent
+	IssueCommentEvent
+	PullRequestCommentEvent
+	OtherEvent
+	ReleaseEvent
+	TagEvent
+	PullRequestReviewEvent
+	PipelineEvent
+	MemberEvent
+	RepoSettingsEvent
+)
+
+// eventTypeNames names the known Event-Type Value. Adding a type takes
+// a constant, an entry here and a Register*Handler for convenience.
+var eventTypeNames = map[int]string{
+	AccessEvent:             "access",
+	PushEvent:               "push",
+	IssueEvent:              "issue",
+	PullRequestEvent:        "pull_request",
+	IssueCommentEvent:       "issue_comment",
+	PullRequestCommentEvent: "pull_request_comment",
+	OtherEvent:              "other",
+	ReleaseEvent:            "release",
+	TagEvent:                "tag",
+	PullRequestReviewEvent:  "pull_request_review",
+	PipelineEvent:           "pipeline",
+	MemberEvent:             "member",
+	RepoSettingsEvent:       "repo_settings",
+}
+
+// KnownEventType tells whether the dispatcher knows eventType.
+func KnownEventType(eventType int) bool {
+	_, ok := eventTypeNames[eventType]
+
+	return ok
+}
+
+// EventTypeName returns the name of eventType, it is empty if the type is unknown.
+func EventTypeName(eventType int) string {
+	return eventTypeNames[eventType]
+}
@@ -0,0 +1,7 @@
+// Package eventpb is the schema of the events passed from the gateway to the
+// robots, it is language neutral, so that the robots can be written in any
+// language. The Go code is generated from event.proto, see framework/codec.go
+// for how GenericEvent is converted to it.
+package eventpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative event.proto
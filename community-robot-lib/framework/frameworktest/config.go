@@ -0,0 +1,76 @@
+package frameworktest
+
+import (
+	"sync"
+	"testing"
+
+	"community-robot-lib/config"
+	"community-robot-lib/framework"
+)
+
+// ConfigSource is a config.ConfigSource kept in memory. Set changes the
+// content and tells the watcher, so that a config.ConfigAgent started with
+// it reloads the config the same way as when the file changes.
+type ConfigSource struct {
+	mut      sync.Mutex
+	content  []byte
+	onChange func()
+}
+
+// NewConfigSource returns the source of content, which is yaml or json.
+func NewConfigSource(content string) *ConfigSource {
+	return &ConfigSource{content: []byte(content)}
+}
+
+func (s *ConfigSource) Read() ([]byte, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return append([]byte(nil), s.content...), nil
+}
+
+func (s *ConfigSource) Watch(onChange func()) {
+	s.mut.Lock()
+	s.onChange = onChange
+	s.mut.Unlock()
+}
+
+func (s *ConfigSource) Stop() {
+	s.mut.Lock()
+	s.onChange = nil
+	s.mut.Unlock()
+}
+
+func (s *ConfigSource) String() string {
+	return "memory"
+}
+
+// Set changes the content, the watcher is called before Set returns.
+func (s *ConfigSource) Set(content string) {
+	s.mut.Lock()
+	s.content = []byte(content)
+	onChange := s.onChange
+	s.mut.Unlock()
+
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// LoadConfig parses content into the config of bot, sets its default values
+// and validates it, as the config agent does. The unknown fields are rejected.
+// The test fails if the config is invalid.
+func LoadConfig(t testing.TB, bot framework.Robot, content string) config.Config {
+	t.Helper()
+
+	c := bot.NewConfig()
+	if err := config.Parse(NewConfigSource(content), c, config.UnknownFieldsReject); err != nil {
+		t.Fatalf("parsing config: %v", err)
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+
+	return c
+}
@@ -0,0 +1,173 @@
+// Package frameworktest helps to test the robots built on the framework
+// without serving them: the events are built from the webhook fixtures,
+// the handlers are called by a fake HandlerRegister, the config is kept in
+// memory and the logs are captured.
+package frameworktest
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"community-robot-lib/framework"
+)
+
+// Platforms are the platforms whose webhooks are recorded, see Fixtures.
+var Platforms = []string{"atomgit", "gitee", "github", "gitlab"}
+
+const fixtureDir = "testdata/webhooks"
+
+//go:embed testdata/webhooks
+var fixtures embed.FS
+
+// Fixture is a webhook and the event which it is parsed into.
+//
+// The file of a fixture is a JSON object of:
+//
+//	headers  the headers of the webhook request
+//	payload  the body of the webhook request
+//	event    the GenericEvent, whose SourcePayload is taken from payload
+//
+// The payload is sent compacted, as the platforms send it, and the signature
+// in headers is computed over the compacted bytes with FixtureSecret, see Sign.
+type Fixture struct {
+	Platform string
+	Name     string
+	Headers  map[string]string
+	Payload  []byte
+
+	// event is kept undecoded, so that each Event is a copy
+	event json.RawMessage
+}
+
+// Fixtures returns the names of the fixtures recorded for platform.
+func Fixtures(platform string) []string {
+	files, _ := fs.Glob(fixtures, path.Join(fixtureDir, platform, "*.json"))
+
+	ans := make([]string, 0, len(files))
+	for _, f := range files {
+		ans = append(ans, strings.TrimSuffix(path.Base(f), ".json"))
+	}
+	sort.Strings(ans)
+
+	return ans
+}
+
+// LoadFixture loads the fixture recorded for platform, see Fixtures for the names.
+func LoadFixture(platform, name string) (*Fixture, error) {
+	b, err := fixtures.ReadFile(path.Join(fixtureDir, platform, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFixture(platform, name, b)
+}
+
+// LoadFixtureFile loads a fixture of the robot, which is written the same
+// way as the recorded ones. The platform is taken from the event.
+func LoadFixtureFile(file string) (*Fixture, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := parseFixture("", strings.TrimSuffix(filepath.Base(file), ".json"), b)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Platform = f.Event().PlatformName
+
+	return f, nil
+}
+
+// MustLoadFixture loads the fixture recorded for platform, the test fails if it can't be loaded.
+func MustLoadFixture(t testing.TB, platform, name string) *Fixture {
+	t.Helper()
+
+	f, err := LoadFixture(platform, name)
+	if err != nil {
+		t.Fatalf("loading fixture %s/%s: %v", platform, name, err)
+	}
+
+	return f
+}
+
+// Event returns the event of the fixture recorded for platform, the test fails if it can't be loaded.
+func Event(t testing.TB, platform, name string) *framework.GenericEvent {
+	t.Helper()
+
+	return MustLoadFixture(t, platform, name).Event()
+}
+
+func parseFixture(platform, name string, b []byte) (*Fixture, error) {
+	var v struct {
+		Headers map[string]string `json:"headers"`
+		Payload json.RawMessage   `json:"payload"`
+		Event   json.RawMessage   `json:"event"`
+	}
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("fixture %s: %v", name, err)
+	}
+
+	if len(v.Event) == 0 {
+		return nil, fmt.Errorf("fixture %s: missing event", name)
+	}
+
+	payload := new(bytes.Buffer)
+	if len(v.Payload) > 0 {
+		if err := json.Compact(payload, v.Payload); err != nil {
+			return nil, fmt.Errorf("fixture %s: %v", name, err)
+		}
+	}
+
+	f := &Fixture{
+		Platform: platform,
+		Name:     name,
+		Headers:  v.Headers,
+		Payload:  payload.Bytes(),
+		event:    v.Event,
+	}
+
+	// fail early rather than at each Event
+	if err := json.Unmarshal(f.event, new(framework.GenericEvent)); err != nil {
+		return nil, fmt.Errorf("fixture %s: %v", name, err)
+	}
+
+	return f, nil
+}
+
+// Event returns a new copy of the event of the fixture,
+// so that it can be changed by the test freely.
+func (f *Fixture) Event() *framework.GenericEvent {
+	evt := new(framework.GenericEvent)
+	_ = json.Unmarshal(f.event, evt)
+
+	if len(f.Payload) > 0 {
+		evt.SourcePayload = append([]byte(nil), f.Payload...)
+	}
+
+	return evt
+}
+
+// Request returns the webhook request which delivers the fixture to target,
+// it can be passed to the PreEventHandlerFunc of the robot, see Register.ParseRequest.
+func (f *Fixture) Request(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(f.Payload))
+	for k, v := range f.Headers {
+		req.Header.Set(k, v)
+	}
+
+	return req
+}
@@ -0,0 +1,285 @@
+package frameworktest
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"community-robot-lib/framework"
+)
+
+var updateSignatures = flag.Bool("update", false, "Sign the fixtures of testdata again with FixtureSecret.")
+
+// the header which carries the event name of each platform
+var eventHeaders = map[string]string{
+	"atomgit": "X-AtomGit-Event",
+	"gitee":   "X-Gitee-Event",
+	"github":  "X-GitHub-Event",
+	"gitlab":  "X-Gitlab-Event",
+}
+
+func TestFixtures(t *testing.T) {
+	for _, platform := range Platforms {
+		names := Fixtures(platform)
+		if len(names) == 0 {
+			t.Errorf("no fixture of %s", platform)
+		}
+
+		for _, name := range names {
+			f := MustLoadFixture(t, platform, name)
+			evt := f.Event()
+
+			if evt.PlatformName != platform {
+				t.Errorf("%s/%s: unexpected platform %s", platform, name, evt.PlatformName)
+			}
+			if v := f.Headers[eventHeaders[platform]]; v != evt.EventName {
+				t.Errorf("%s/%s: event name %s differs from the header %s", platform, name, evt.EventName, v)
+			}
+			if string(evt.SourcePayload) != string(f.Payload) || len(f.Payload) == 0 {
+				t.Errorf("%s/%s: expected the payload as the source payload", platform, name)
+			}
+			if evt.Org == "" || evt.Repo == "" || evt.Sender.Login == "" {
+				t.Errorf("%s/%s: missing org, repo or sender", platform, name)
+			}
+
+			req := f.Request("/hook")
+			if v := req.Header.Get(eventHeaders[platform]); v != evt.EventName {
+				t.Errorf("%s/%s: expected the headers in request, got %s", platform, name, v)
+			}
+			if b, _ := io.ReadAll(req.Body); string(b) != string(f.Payload) {
+				t.Errorf("%s/%s: expected the payload as the body of request", platform, name)
+			}
+		}
+	}
+}
+
+func TestFixtureEventIsCopy(t *testing.T) {
+	f := MustLoadFixture(t, "gitee", "push")
+
+	evt := f.Event()
+	evt.Org = "changed"
+	evt.Commits[0].Added[0] = "changed"
+	evt.SourcePayload[0] = ' '
+
+	if v := f.Event(); v.Org == "changed" || v.Commits[0].Added[0] == "changed" || v.SourcePayload[0] == ' ' {
+		t.Errorf("expected a new copy of event, got %+v", v)
+	}
+}
+
+func TestLoadFixtureFile(t *testing.T) {
+	f, err := LoadFixtureFile(filepath.Join(fixtureDir, "github", "push.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Platform != "github" || f.Name != "push" {
+		t.Errorf("unexpected fixture %s/%s", f.Platform, f.Name)
+	}
+
+	if _, err := LoadFixture("github", "missing"); err == nil {
+		t.Error("expected an error of missing fixture")
+	}
+}
+
+// TestFixtureSignatures checks that every fixture is signed with FixtureSecret.
+// Run the test with -update after changing a payload to sign it again.
+func TestFixtureSignatures(t *testing.T) {
+	for _, platform := range Platforms {
+		for _, name := range Fixtures(platform) {
+			f := MustLoadFixture(t, platform, name)
+			want := signatureHeaders(platform, f.Headers, f.Payload, FixtureSecret)
+			if len(want) == 0 {
+				t.Fatalf("%s: no signature header", platform)
+			}
+
+			if *updateSignatures {
+				resign(t, platform, name, f.Headers, want)
+				continue
+			}
+
+			for k, v := range want {
+				if f.Headers[k] != v {
+					t.Errorf("%s/%s: expected %s of %s, got %s", platform, name, k, v, f.Headers[k])
+				}
+			}
+		}
+	}
+}
+
+// resign replaces the values of the signature headers in the file of fixture,
+// the rest of the file is kept as it is.
+func resign(t *testing.T, platform, name string, headers, signature map[string]string) {
+	file := filepath.Join(fixtureDir, platform, name+".json")
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := string(b)
+	for k, v := range signature {
+		old := fmt.Sprintf("%q: %q", k, headers[k])
+		if !strings.Contains(s, old) {
+			t.Fatalf("%s/%s: header %s not found", platform, name, k)
+		}
+
+		s = strings.Replace(s, old, fmt.Sprintf("%q: %q", k, v), 1)
+	}
+
+	if err := os.WriteFile(file, []byte(s), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFixtureSign(t *testing.T) {
+	f := MustLoadFixture(t, "github", "push")
+	v := f.Headers["X-Hub-Signature-256"]
+
+	f.Sign("another")
+
+	if f.Headers["X-Hub-Signature-256"] == v {
+		t.Error("expected the signature to change")
+	}
+	if MustLoadFixture(t, "github", "push").Headers["X-Hub-Signature-256"] != v {
+		t.Error("expected the fixture loaded again to be signed with FixtureSecret")
+	}
+}
+
+var (
+	prNumber  = func(e *framework.GenericEvent) string { return e.PRNumber }
+	sender    = func(e *framework.GenericEvent) string { return e.Sender.Login }
+	action    = func(e *framework.GenericEvent) string { return e.Action }
+	headRef   = func(e *framework.GenericEvent) string { return e.HeadRef }
+	baseRef   = func(e *framework.GenericEvent) string { return e.BaseRef }
+	headSHA   = func(e *framework.GenericEvent) string { return e.HeadSHA }
+	prComment = func(e *framework.GenericEvent) string { return e.PRComment }
+	pushRef   = func(e *framework.GenericEvent) string { return e.Ref }
+	pushBase  = func(e *framework.GenericEvent) string { return e.Base }
+	pushHead  = func(e *framework.GenericEvent) string { return e.Head }
+)
+
+type eventField = func(*framework.GenericEvent) string
+
+var (
+	// the fields of the pull request and comment webhooks of atomgit, gitee and github
+	hubPullRequest = map[string]eventField{
+		"action":                  action,
+		"number":                  prNumber,
+		"sender.login":            sender,
+		"pull_request.head.ref":   headRef,
+		"pull_request.base.ref":   baseRef,
+		"pull_request.head.sha":   headSHA,
+		"pull_request.user.login": func(e *framework.GenericEvent) string { return e.PRAuthor },
+	}
+	hubComment = map[string]eventField{
+		"action":                action,
+		"pull_request.number":   prNumber,
+		"sender.login":          sender,
+		"comment.body":          prComment,
+		"pull_request.head.ref": headRef,
+		"pull_request.base.ref": baseRef,
+	}
+	hubPush = map[string]eventField{
+		"ref":          pushRef,
+		"before":       pushBase,
+		"after":        pushHead,
+		"sender.login": sender,
+	}
+)
+
+// payloadFields maps the fields of the payload of each fixture to the ones of its event.
+var payloadFields = map[string]map[string]eventField{
+	"atomgit/pull_request":         hubPullRequest,
+	"atomgit/pull_request_comment": hubComment,
+	"atomgit/push":                 hubPush,
+	"gitee/pull_request":           hubPullRequest,
+	"gitee/pull_request_comment":   hubComment,
+	"gitee/push":                   hubPush,
+	"github/pull_request":          hubPullRequest,
+	"github/pull_request_comment": {
+		"action":       action,
+		"issue.number": prNumber,
+		"sender.login": sender,
+		"comment.body": prComment,
+	},
+	"github/push": hubPush,
+	"gitlab/pull_request": {
+		"object_attributes.action":         action,
+		"object_attributes.iid":            prNumber,
+		"user.username":                    sender,
+		"object_attributes.source_branch":  headRef,
+		"object_attributes.target_branch":  baseRef,
+		"object_attributes.last_commit.id": headSHA,
+	},
+	"gitlab/pull_request_comment": {
+		"merge_request.iid":           prNumber,
+		"user.username":               sender,
+		"object_attributes.note":      prComment,
+		"merge_request.source_branch": headRef,
+		"merge_request.target_branch": baseRef,
+	},
+	"gitlab/push": {
+		"ref":           pushRef,
+		"before":        pushBase,
+		"after":         pushHead,
+		"user_username": sender,
+	},
+}
+
+// TestFixturePayloadMatchesEvent keeps the event of each fixture in line with its payload.
+func TestFixturePayloadMatchesEvent(t *testing.T) {
+	for _, platform := range Platforms {
+		for _, name := range Fixtures(platform) {
+			id := platform + "/" + name
+
+			fields, ok := payloadFields[id]
+			if !ok {
+				t.Errorf("%s: no payload fields to check", id)
+				continue
+			}
+
+			f := MustLoadFixture(t, platform, name)
+			evt := f.Event()
+
+			var payload interface{}
+			if err := json.Unmarshal(f.Payload, &payload); err != nil {
+				t.Fatalf("%s: %v", id, err)
+			}
+
+			for path, field := range fields {
+				v, ok := lookup(payload, path)
+				if !ok {
+					t.Errorf("%s: missing %s in payload", id, path)
+				} else if got := field(evt); got != v {
+					t.Errorf("%s: %s is %s in payload but %s in event", id, path, v, got)
+				}
+			}
+		}
+	}
+}
+
+// lookup returns the value at the dotted path of the decoded JSON.
+func lookup(v interface{}, path string) (string, bool) {
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+
+		if v, ok = m[k]; !ok {
+			return "", false
+		}
+	}
+
+	switch v.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		return "", false
+	}
+
+	return fmt.Sprint(v), true
+}
@@ -0,0 +1,120 @@
+package frameworktest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// Logs captures the log entries, either of its own logger, whose Entry is
+// passed to the handlers, or of the standard logger, see CaptureStandardLogger.
+type Logs struct {
+	hook   *test.Hook
+	logger *logrus.Logger
+}
+
+// NewLogs returns the logs of a logger which writes nothing but captures
+// every entry down to the debug level.
+func NewLogs() *Logs {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+
+	return &Logs{hook: hook, logger: logger}
+}
+
+// CaptureStandardLogger captures the entries of the standard logger
+// until the test ends, for the robots which log by logrus directly.
+func CaptureStandardLogger(t testing.TB) *Logs {
+	std := logrus.StandardLogger()
+
+	hooks := make(logrus.LevelHooks, len(std.Hooks))
+	for k, v := range std.Hooks {
+		hooks[k] = append([]logrus.Hook(nil), v...)
+	}
+
+	hook := test.NewLocal(std)
+	t.Cleanup(func() { std.ReplaceHooks(hooks) })
+
+	return &Logs{hook: hook, logger: std}
+}
+
+// Entry returns the entry to pass to the handlers, fields are usually
+// the ones of GenericEvent.CollectLogFiled.
+func (l *Logs) Entry(fields logrus.Fields) *logrus.Entry {
+	return l.logger.WithFields(fields)
+}
+
+// Entries returns the captured entries in the order they are logged.
+func (l *Logs) Entries() []*logrus.Entry {
+	return l.hook.AllEntries()
+}
+
+// Reset drops the captured entries.
+func (l *Logs) Reset() {
+	l.hook.Reset()
+}
+
+// Find returns the first entry of level whose message or error contains substr, or nil.
+func (l *Logs) Find(level logrus.Level, substr string) *logrus.Entry {
+	for _, e := range l.hook.AllEntries() {
+		if e.Level == level && strings.Contains(entryText(e), substr) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// AssertLogged fails the test if no entry of level contains substr.
+func (l *Logs) AssertLogged(t testing.TB, level logrus.Level, substr string) *logrus.Entry {
+	t.Helper()
+
+	e := l.Find(level, substr)
+	if e == nil {
+		t.Errorf("expected %s log containing %q, got:\n%s", level, substr, l)
+	}
+
+	return e
+}
+
+// AssertNotLogged fails the test if an entry of level contains substr.
+func (l *Logs) AssertNotLogged(t testing.TB, level logrus.Level, substr string) {
+	t.Helper()
+
+	if e := l.Find(level, substr); e != nil {
+		t.Errorf("unexpected %s log: %s", level, entryText(e))
+	}
+}
+
+// AssertNoErrors fails the test if anything is logged at the error level or above.
+func (l *Logs) AssertNoErrors(t testing.TB) {
+	t.Helper()
+
+	for _, e := range l.hook.AllEntries() {
+		if e.Level <= logrus.ErrorLevel {
+			t.Errorf("unexpected %s log: %s", e.Level, entryText(e))
+		}
+	}
+}
+
+// String lists the captured entries, one per line.
+func (l *Logs) String() string {
+	var b strings.Builder
+
+	for _, e := range l.hook.AllEntries() {
+		fmt.Fprintf(&b, "%s: %s\n", e.Level, entryText(e))
+	}
+
+	return b.String()
+}
+
+func entryText(e *logrus.Entry) string {
+	if err, ok := e.Data[logrus.ErrorKey]; ok {
+		return fmt.Sprintf("%s %v", e.Message, err)
+	}
+
+	return e.Message
+}
@@ -0,0 +1,79 @@
+package frameworktest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+	"community-robot-lib/framework"
+	"community-robot-lib/framework/internal/localdispatch"
+)
+
+var _ framework.HandlerRegister = (*Register)(nil)
+
+// localDispatcher is the dispatcher of framework which calls the handlers of a
+// robot without serving it, see localdispatch.
+type localDispatcher interface {
+	framework.HandlerRegister
+
+	PreEventHandler() framework.PreEventHandlerFunc
+	Handlers(eventType int) int
+	Dispatch(ctx context.Context, evt *framework.GenericEvent, cnf config.Config, lgr *logrus.Entry) error
+}
+
+// Register is a framework.HandlerRegister which keeps what the robot registers
+// and calls the handlers synchronously, without the server. It is backed by the
+// dispatcher of framework, so the handlers are called the same way as when the
+// robot is served: with the middlewares layered around them, the panics
+// recovered and the time budget of framework.HandlerTimeoutConfig applied.
+type Register struct {
+	localDispatcher
+}
+
+// NewRegister returns the register which bot registered its handlers to, bot can
+// be nil, then the handlers are expected to be registered by the test itself.
+func NewRegister(bot framework.Robot) *Register {
+	return &Register{localdispatch.New(bot).(localDispatcher)}
+}
+
+// Handle calls the handlers of the event, see HandleContext.
+func (r *Register) Handle(evt *framework.GenericEvent, cnf config.Config, lgr *logrus.Entry) error {
+	return r.HandleContext(context.Background(), evt, cnf, lgr)
+}
+
+// HandleContext calls every handler of the event even if one of them fails,
+// and returns the errors of them joined. lgr can be nil, then the standard
+// logger is used, see Logs for capturing what the handlers log.
+func (r *Register) HandleContext(
+	ctx context.Context, evt *framework.GenericEvent, cnf config.Config, lgr *logrus.Entry,
+) error {
+	if r.Handlers(evt.EventType) == 0 {
+		return fmt.Errorf("no handler of event type %d", evt.EventType)
+	}
+
+	if lgr == nil {
+		lgr = logrus.WithFields(evt.CollectLogFiled())
+	}
+
+	return r.Dispatch(ctx, evt, cnf, lgr)
+}
+
+// ParseRequest calls the PreEventHandlerFunc of the robot with req, such as
+// the one of Fixture.Request, and returns the event and what is replied.
+// The event is nil if the request is rejected.
+func (r *Register) ParseRequest(req *http.Request) (*framework.GenericEvent, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+
+	pre := r.PreEventHandler()
+	if pre == nil {
+		http.Error(w, "no pre event handler", http.StatusNotImplemented)
+
+		return nil, w
+	}
+
+	return pre(w, req), w
+}
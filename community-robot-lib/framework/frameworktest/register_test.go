@@ -0,0 +1,205 @@
+package frameworktest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+	"community-robot-lib/framework"
+)
+
+type demoConfig struct {
+	Command string `json:"command"`
+}
+
+func (c *demoConfig) Validate() error {
+	if c.Command == "" {
+		return errors.New("missing command")
+	}
+
+	return nil
+}
+
+func (c *demoConfig) SetDefault() {}
+
+// demoRobot replies to the command on PR, it is how a robot is expected to be tested.
+type demoRobot struct {
+	calls []string
+}
+
+func (bot *demoRobot) NewConfig() config.Config {
+	return &demoConfig{}
+}
+
+func (bot *demoRobot) RegisterEventHandler(r framework.HandlerRegister) {
+	r.RegisterPreEventHandler(func(w http.ResponseWriter, req *http.Request) *framework.GenericEvent {
+		if req.Header.Get("X-Gitee-Event") == "" {
+			http.Error(w, "not a webhook of gitee", http.StatusBadRequest)
+			return nil
+		}
+
+		evt := new(framework.GenericEvent)
+		evt.PlatformName = "gitee"
+		evt.EventName = req.Header.Get("X-Gitee-Event")
+
+		return evt
+	})
+
+	r.RegisterMiddleware(framework.Middleware{
+		Pre: func(evt *framework.GenericEvent, _ config.Config, _ *logrus.Entry) error {
+			bot.calls = append(bot.calls, "pre")
+			return nil
+		},
+		Post: func(evt *framework.GenericEvent, _ config.Config, lgr *logrus.Entry, err error, _ time.Duration) {
+			bot.calls = append(bot.calls, "post")
+		},
+	}, framework.PullRequestCommentEvent)
+
+	r.RegisterPullRequestCommentHandler(func(evt *framework.GenericEvent, cnf config.Config, lgr *logrus.Entry) error {
+		bot.calls = append(bot.calls, "comment")
+
+		if evt.PRComment != cnf.(*demoConfig).Command {
+			lgr.Debug("ignoring comment")
+			return nil
+		}
+
+		lgr.WithField("pr", evt.PRNumber).Info("command received")
+
+		return nil
+	})
+
+	r.RegisterPullRequestCommentHandler(func(evt *framework.GenericEvent, _ config.Config, lgr *logrus.Entry) error {
+		return fmt.Errorf("failed to comment on %s", evt.PRNumber)
+	})
+}
+
+func TestRegister(t *testing.T) {
+	bot := new(demoRobot)
+	r := NewRegister(bot)
+	cnf := LoadConfig(t, bot, "command: /lgtm")
+	logs := NewLogs()
+
+	if n := r.Handlers(framework.PullRequestCommentEvent); n != 2 {
+		t.Fatalf("expected 2 handlers of comment, got %d", n)
+	}
+
+	for _, platform := range Platforms {
+		logs.Reset()
+		bot.calls = nil
+
+		evt := Event(t, platform, "pull_request_comment")
+		err := r.Handle(evt, cnf, logs.Entry(evt.CollectLogFiled()))
+		if err == nil || err.Error() != "failed to comment on 12" {
+			t.Errorf("%s: expected the error of the second handler, got %v", platform, err)
+		}
+
+		e := logs.AssertLogged(t, logrus.InfoLevel, "command received")
+		if e != nil && (e.Data["pr"] != "12" || e.Data["org"] != "opensourceways") {
+			t.Errorf("%s: unexpected fields %v", platform, e.Data)
+		}
+		logs.AssertNotLogged(t, logrus.DebugLevel, "ignoring comment")
+		logs.AssertNoErrors(t)
+
+		// the middleware is called once around both handlers
+		want := []string{"pre", "comment", "post"}
+		if fmt.Sprint(bot.calls) != fmt.Sprint(want) {
+			t.Errorf("%s: expected calls %v, got %v", platform, want, bot.calls)
+		}
+	}
+
+	if err := r.Handle(Event(t, "gitee", "push"), cnf, nil); err == nil {
+		t.Error("expected an error of event without handler")
+	}
+}
+
+type timeoutConfig struct{ demoConfig }
+
+func (c *timeoutConfig) HandlerTimeout(int) time.Duration { return time.Millisecond }
+
+// TestRegisterAsServed checks the handlers are called the same way as when the robot is served.
+func TestRegisterAsServed(t *testing.T) {
+	r := NewRegister(nil)
+
+	var postErr error
+	r.RegisterMiddleware(framework.Middleware{
+		Post: func(_ *framework.GenericEvent, _ config.Config, _ *logrus.Entry, err error, _ time.Duration) {
+			postErr = err
+		},
+	})
+
+	var deadline bool
+	r.RegisterContextHandler(framework.PushEvent, func(
+		ctx context.Context, _ *framework.GenericEvent, _ config.Config, _ *logrus.Entry,
+	) error {
+		_, deadline = ctx.Deadline()
+
+		return nil
+	})
+	r.RegisterPushCodeBranchTagHandler(func(*framework.GenericEvent, config.Config, *logrus.Entry) error {
+		panic("boom")
+	})
+
+	err := r.Handle(Event(t, "gitee", "push"), &timeoutConfig{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the panic to be returned, got %v", err)
+	}
+	if postErr == nil || !strings.Contains(postErr.Error(), "boom") {
+		t.Errorf("expected the panic to be passed to Post, got %v", postErr)
+	}
+	if !deadline {
+		t.Error("expected the timeout of HandlerTimeoutConfig to be applied")
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	r := NewRegister(new(demoRobot))
+
+	evt, w := r.ParseRequest(MustLoadFixture(t, "gitee", "push").Request("/gitee-hook"))
+	if evt == nil || evt.EventName != "Push Hook" {
+		t.Errorf("unexpected event %+v, %s", evt, w.Body)
+	}
+
+	evt, w = r.ParseRequest(MustLoadFixture(t, "github", "push").Request("/gitee-hook"))
+	if evt != nil || w.Code != http.StatusBadRequest {
+		t.Errorf("expected the request to be rejected, got %+v, %d", evt, w.Code)
+	}
+}
+
+func TestConfigSource(t *testing.T) {
+	bot := new(demoRobot)
+	src := NewConfigSource("command: /lgtm")
+
+	agent := config.NewConfigAgent(bot.NewConfig)
+	if err := agent.StartSource(src); err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Stop()
+
+	src.Set(`{"command": "/approve"}`)
+
+	_, c := agent.GetConfig()
+	if v := c.(*demoConfig).Command; v != "/approve" {
+		t.Errorf("expected the config to be reloaded, got %s", v)
+	}
+}
+
+func TestCaptureStandardLogger(t *testing.T) {
+	logs := CaptureStandardLogger(t)
+
+	logrus.WithError(errors.New("boom")).Error("handling event")
+
+	if e := logs.Find(logrus.ErrorLevel, "boom"); e == nil {
+		t.Errorf("expected the error to be captured, got:\n%s", logs)
+	}
+
+	if n := len(logs.Entries()); n != 1 {
+		t.Errorf("expected 1 entry, got %d", n)
+	}
+}
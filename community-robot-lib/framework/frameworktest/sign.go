@@ -0,0 +1,61 @@
+package frameworktest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// FixtureSecret is the webhook secret which the fixtures are signed with,
+// the robot which checks the signature of webhooks is set up with it in the
+// tests, or the fixture is signed again with the secret of the robot by Sign.
+const FixtureSecret = "frameworktest-webhook-secret"
+
+// Sign signs the payload of the fixture with secret the way its platform does:
+//
+//	atomgit  X-AtomGit-Signature-256, sha256= and the hex of HMAC-SHA256 of the payload
+//	gitee    X-Gitee-Token, the base64 of HMAC-SHA256 of X-Gitee-Timestamp, "\n" and the secret
+//	github   X-Hub-Signature-256, sha256= and the hex of HMAC-SHA256 of the payload
+//	gitlab   X-Gitlab-Token, the secret itself
+func (f *Fixture) Sign(secret string) {
+	headers := make(map[string]string, len(f.Headers))
+	for k, v := range f.Headers {
+		headers[k] = v
+	}
+
+	for k, v := range signatureHeaders(f.Platform, f.Headers, f.Payload, secret) {
+		headers[k] = v
+	}
+
+	// the headers may be shared with the fixture loaded before
+	f.Headers = headers
+}
+
+// signatureHeaders returns the headers which carry the signature of payload.
+func signatureHeaders(platform string, headers map[string]string, payload []byte, secret string) map[string]string {
+	switch platform {
+	case "atomgit":
+		return map[string]string{"X-AtomGit-Signature-256": "sha256=" + hex.EncodeToString(hmacSHA256(secret, payload))}
+
+	case "gitee":
+		v := hmacSHA256(secret, []byte(headers["X-Gitee-Timestamp"]+"\n"+secret))
+
+		return map[string]string{"X-Gitee-Token": base64.StdEncoding.EncodeToString(v)}
+
+	case "github":
+		return map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(hmacSHA256(secret, payload))}
+
+	case "gitlab":
+		return map[string]string{"X-Gitlab-Token": secret}
+	}
+
+	return nil
+}
+
+func hmacSHA256(secret string, data []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(data)
+
+	return h.Sum(nil)
+}
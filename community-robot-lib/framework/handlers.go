@@ -1,12 +1,11 @@
 package framework
 
 import (
-	"bytes"
 	"community-robot-lib/config"
-	"encoding/gob"
-	"errors"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"sort"
+	"time"
 )
 
 type EventHeader struct {
@@ -15,12 +14,54 @@ type EventHeader struct {
 	EventName    string // "Push Hook" => PushEvent , "Issue Hook" => IssueEvent,
 	// "Merge Request Hook" => PullRequestEvent, "Note Hook" => IssueCommentEvent & PullRequestCommentEvent
 	EventUUID string // event id
+
+	SupersededCount int // number of events of the same burst which were coalesced into this one
+
+	Sender    User      // who triggered the event
+	CreatedAt time.Time // when the object of the event, such as the PR, was created
+	UpdatedAt time.Time // when the object of the event was updated by this event
+}
+
+// User is an account of the platform.
+type User struct {
+	Login string
+	Name  string
+	Email string
+}
+
+// Commit is a commit pushed by a PushEvent, the files are the paths relative to the root of repo.
+type Commit struct {
+	SHA       string
+	Message   string
+	Author    User
+	Timestamp time.Time
+	Added     []string
+	Modified  []string
+	Removed   []string
 }
 
+// Merge state of PR
+const (
+	MergeStateUnknown     = ""
+	MergeStateMergeable   = "mergeable"
+	MergeStateConflicting = "conflicting"
+	MergeStateMerged      = "merged"
+)
+
+// Review state of PR
+const (
+	ReviewStateApproved         = "approved"
+	ReviewStateChangesRequested = "changes_requested"
+	ReviewStateCommented        = "commented"
+)
+
 // PushPayload data come from PushEvent
 type PushPayload struct {
 	Base string
 	Head string
+
+	Ref     string // the full ref pushed, such as refs/heads/master
+	Commits []Commit
 }
 
 type IssuePayload struct {
@@ -37,6 +78,13 @@ type PullRequestPayload struct {
 	PRComment   string
 	PRCommenter string
 	IssueLabels []string
+
+	BaseRef     string
+	BaseSHA     string
+	HeadRef     string
+	HeadSHA     string
+	MergeState  string // one of MergeState*
+	ReviewState string // one of ReviewState*, set by the events of review
 }
 
 type EventPayload struct {
@@ -47,6 +95,12 @@ type EventPayload struct {
 	PushPayload
 	IssuePayload
 	PullRequestPayload
+
+	// the fields shared by issue and PR
+	Assignees     []string
+	Milestone     string
+	LabelsAdded   []string // the labels added by this event
+	LabelsRemoved []string // the labels removed by this event
 }
 
 type GenericEvent struct {
@@ -57,14 +111,23 @@ type GenericEvent struct {
 
 type GenericHandlerFunc func(evt *GenericEvent, cnf config.Config, lgr *logrus.Entry) error
 
+// eventHandler holds the handlers of each event type in the order they are registered.
 type eventHandler struct {
-	accessHandler             GenericHandlerFunc
-	pushCodeBranchTagHandler  GenericHandlerFunc
-	issueHandlers             GenericHandlerFunc
-	pullRequestHandler        GenericHandlerFunc
-	issueCommentHandler       GenericHandlerFunc
-	pullRequestCommentHandler GenericHandlerFunc
-	otherHandler              GenericHandlerFunc
+	byType map[int][]namedHandler
+}
+
+// add appends the handler of eventType, it returns false if the type is unknown.
+func (h *eventHandler) add(eventType int, nh namedHandler) bool {
+	if !KnownEventType(eventType) {
+		return false
+	}
+
+	if h.byType == nil {
+		h.byType = make(map[int][]namedHandler)
+	}
+	h.byType[eventType] = append(h.byType[eventType], nh)
+
+	return true
 }
 
 type PreEventHandlerFunc func(w http.ResponseWriter, r *http.Request) *GenericEvent
@@ -74,7 +137,7 @@ type preEventHandler struct {
 }
 
 type postEventHandler struct {
-	// expansion
+	middlewares []middlewareEntry
 }
 
 type handlers struct {
@@ -87,44 +150,108 @@ func (h *handlers) RegisterPreEventHandler(fn PreEventHandlerFunc) {
 	h.reqHandler = fn
 }
 
+// RegisterMiddleware registers a middleware around the handlers of eventTypes,
+// which are the Event-Type Value, or around all the handlers if there is none.
+func (h *handlers) RegisterMiddleware(m Middleware, eventTypes ...int) {
+	h.middlewares = append(h.middlewares, middlewareEntry{m: m, eventTypes: eventTypes})
+}
+
+// RegisterHandler registers a handler of eventType, which is the Event-Type Value.
+// Each of the Register*Handler can be called more than once, every handler
+// registered for the event type is called, see ServiceOptions.ParallelHandlers.
+func (h *handlers) RegisterHandler(eventType int, fn GenericHandlerFunc) {
+	if !h.add(eventType, newNamedHandler(fn)) {
+		logrus.Errorf("Ignoring handler of unknown event type %d", eventType)
+	}
+}
+
+// RegisterContextHandler registers a handler of eventType the same way
+// as RegisterHandler except that it is called with a context.
+func (h *handlers) RegisterContextHandler(eventType int, fn ContextHandlerFunc) {
+	if !h.add(eventType, namedHandler{name: handlerName(fn), fn: fn}) {
+		logrus.Errorf("Ignoring handler of unknown event type %d", eventType)
+	}
+}
+
 // RegisterAccessHandler registers a plugin's AccessEvent handler.
 func (h *handlers) RegisterAccessHandler(fn GenericHandlerFunc) {
-	h.accessHandler = fn
+	h.RegisterHandler(AccessEvent, fn)
 }
 
 // RegisterPushCodeBranchTagHandler registers a plugin's PushEvent handler.
-// source code push event、branch push/delete event、tag push/delete event
+// source code push event、branch push/delete event、tag push/delete event.
+// A tag push is always a PushEvent whose Ref starts with refs/tags/: the
+// "Tag Push Hook" of gitee and gitlab, the "tag_push" of atomgit and the
+// "push" of github, it is never dispatched as TagEvent.
 func (h *handlers) RegisterPushCodeBranchTagHandler(fn GenericHandlerFunc) {
-	h.pushCodeBranchTagHandler = fn
+	h.RegisterHandler(PushEvent, fn)
 }
 
 // RegisterIssueHandler registers a plugin's IssueEvent handler.
 // issue create/delete event、issue status change event、issue reviewer event
 func (h *handlers) RegisterIssueHandler(fn GenericHandlerFunc) {
-	h.issueHandlers = fn
+	h.RegisterHandler(IssueEvent, fn)
 }
 
 // RegisterPullRequestHandler registers a plugin's PullRequestEvent handler.
 // PR create/update/merge/close event、PR label create/update/delete event、PR associate(or cancel) issue event
 func (h *handlers) RegisterPullRequestHandler(fn GenericHandlerFunc) {
-	h.pullRequestHandler = fn
+	h.RegisterHandler(PullRequestEvent, fn)
 }
 
 // RegisterIssueCommentHandler registers a plugin's IssueCommentEvent handler.
 // issue comment add event
 func (h *handlers) RegisterIssueCommentHandler(fn GenericHandlerFunc) {
-	h.issueCommentHandler = fn
+	h.RegisterHandler(IssueCommentEvent, fn)
 }
 
 // RegisterPullRequestCommentHandler registers a plugin's PullRequestCommentEvent handler.
 // PR comment add event
 func (h *handlers) RegisterPullRequestCommentHandler(fn GenericHandlerFunc) {
-	h.pullRequestCommentHandler = fn
+	h.RegisterHandler(PullRequestCommentEvent, fn)
 }
 
 // RegisterOtherHandler registers a plugin's OtherEvent handler.
 func (h *handlers) RegisterOtherHandler(fn GenericHandlerFunc) {
-	h.otherHandler = fn
+	h.RegisterHandler(OtherEvent, fn)
+}
+
+// RegisterReleaseHandler registers a plugin's ReleaseEvent handler.
+// release create/update/delete event
+func (h *handlers) RegisterReleaseHandler(fn GenericHandlerFunc) {
+	h.RegisterHandler(ReleaseEvent, fn)
+}
+
+// RegisterTagHandler registers a plugin's TagEvent handler.
+// tag create/delete event which a platform sends apart from the push, such as
+// the "create" and "delete" of github whose ref_type is tag. The tag pushes
+// are dispatched as PushEvent, see RegisterPushCodeBranchTagHandler.
+func (h *handlers) RegisterTagHandler(fn GenericHandlerFunc) {
+	h.RegisterHandler(TagEvent, fn)
+}
+
+// RegisterPullRequestReviewHandler registers a plugin's PullRequestReviewEvent handler.
+// PR review submit/dismiss event
+func (h *handlers) RegisterPullRequestReviewHandler(fn GenericHandlerFunc) {
+	h.RegisterHandler(PullRequestReviewEvent, fn)
+}
+
+// RegisterPipelineHandler registers a plugin's PipelineEvent handler.
+// CI pipeline, job and commit status event
+func (h *handlers) RegisterPipelineHandler(fn GenericHandlerFunc) {
+	h.RegisterHandler(PipelineEvent, fn)
+}
+
+// RegisterMemberHandler registers a plugin's MemberEvent handler.
+// member add/remove/role change event of org or repo
+func (h *handlers) RegisterMemberHandler(fn GenericHandlerFunc) {
+	h.RegisterHandler(MemberEvent, fn)
+}
+
+// RegisterRepoSettingsHandler registers a plugin's RepoSettingsEvent handler.
+// repo create/rename/visibility/branch protection change event
+func (h *handlers) RegisterRepoSettingsHandler(fn GenericHandlerFunc) {
+	h.RegisterHandler(RepoSettingsEvent, fn)
 }
 
 func (ge *GenericEvent) CollectLogFiled() map[string]interface{} {
@@ -153,31 +280,45 @@ func (ge *GenericEvent) CollectLogFiled() map[string]interface{} {
 	if ge.HtmlURL != "" {
 		m["url"] = ge.HtmlURL
 	}
+	if ge.Sender.Login != "" {
+		m["sender"] = ge.Sender.Login
+	}
 
 	return m
 }
 
-func (ge *GenericEvent) ConvertToBytes() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	enc := gob.NewEncoder(buf)
-	if err := enc.Encode(ge); err != nil {
-		return nil, err
+// OrderingKey identifies the PR or issue the event belongs to, in the form of
+// org/repo#number. Events which don't belong to one have an empty key.
+func (ge *GenericEvent) OrderingKey() string {
+	n := ge.PRNumber
+	if n == "" {
+		n = ge.IssueNumber
+	}
+
+	if n == "" || ge.Repo == "" {
+		return ""
 	}
 
-	return buf.Bytes(), nil
+	return ge.Org + "/" + ge.Repo + "#" + n
 }
 
-func (ge *GenericEvent) ConvertFromBytes(b []byte) error {
-	if b == nil {
-		return errors.New("no data to convert")
+// ChangedFiles returns the files added, modified or removed by the pushed commits, sorted.
+func (ge *GenericEvent) ChangedFiles() []string {
+	m := make(map[string]struct{})
+	for i := range ge.Commits {
+		c := &ge.Commits[i]
+		for _, files := range [][]string{c.Added, c.Modified, c.Removed} {
+			for _, f := range files {
+				m[f] = struct{}{}
+			}
+		}
 	}
 
-	buf := new(bytes.Buffer)
-	buf.Write(b)
-	dec := gob.NewDecoder(buf)
-	if err := dec.Decode(ge); err != nil {
-		return err
+	ans := make([]string, 0, len(m))
+	for f := range m {
+		ans = append(ans, f)
 	}
+	sort.Strings(ans)
 
-	return nil
+	return ans
 }
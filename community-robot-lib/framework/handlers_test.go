@@ -0,0 +1,15 @@
+package framework
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangedFiles(t *testing.T) {
+	evt := codecEvent()
+	evt.Commits = append(evt.Commits, Commit{SHA: "c", Removed: []string{"a.go"}})
+
+	if v := evt.ChangedFiles(); !reflect.DeepEqual(v, []string{"a.go", "b.go", "c.go"}) {
+		t.Errorf("unexpected changed files: %v", v)
+	}
+}
@@ -0,0 +1,7 @@
+// Package localdispatch lets frameworktest reach the dispatcher which calls the
+// handlers of a robot without serving it, while it stays out of the api of framework.
+package localdispatch
+
+// New returns the local dispatcher which bot, a framework.Robot or nil,
+// registered its handlers to. It is set by framework when it is loaded.
+var New func(bot interface{}) interface{}
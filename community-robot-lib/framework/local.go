@@ -0,0 +1,69 @@
+package framework
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+	"community-robot-lib/framework/internal/localdispatch"
+)
+
+var _ HandlerRegister = (*localDispatcher)(nil)
+
+func init() {
+	localdispatch.New = func(bot interface{}) interface{} {
+		r, _ := bot.(Robot)
+
+		return newLocalDispatcher(r)
+	}
+}
+
+// localDispatcher is a HandlerRegister which calls the handlers of a robot
+// without serving it, for frameworktest. The handlers are called by Dispatch
+// the same way as the dispatcher of the server calls them: with the middlewares
+// layered around them, the panics recovered and the time budget of
+// HandlerTimeoutConfig, but one after another and synchronously.
+type localDispatcher struct {
+	handlers
+}
+
+// newLocalDispatcher returns the dispatcher which bot registered its handlers
+// to, bot can be nil, then the handlers are registered by the caller.
+func newLocalDispatcher(bot Robot) *localDispatcher {
+	d := new(localDispatcher)
+
+	if bot != nil {
+		bot.RegisterEventHandler(d)
+	}
+
+	return d
+}
+
+// PreEventHandler returns the PreEventHandlerFunc registered, or nil.
+func (d *localDispatcher) PreEventHandler() PreEventHandlerFunc {
+	return d.reqHandler
+}
+
+// Handlers returns the number of handlers registered for eventType.
+func (d *localDispatcher) Handlers(eventType int) int {
+	return len(d.byType[eventType])
+}
+
+// Dispatch calls every handler of the event even if one of them fails, and
+// returns the errors of them joined. The panic of a handler is returned as an
+// error too. The handlers are registered before Dispatch is called.
+func (d *localDispatcher) Dispatch(ctx context.Context, evt *GenericEvent, cnf config.Config, lgr *logrus.Entry) error {
+	hs := d.index()[evt.EventType]
+	if hs == nil {
+		return nil
+	}
+
+	ctx, cancel := newHandlerContext(ctx, 0, evt.EventType, cnf)
+	defer cancel()
+
+	errs, rejected := hs.run(ctx, evt, cnf, lgr, false)
+
+	return errors.Join(append([]error{rejected}, errs...)...)
+}
@@ -0,0 +1,83 @@
+package framework
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// eventMetrics are the counters of the events handled by a server. They are
+// kept apart from the process wide vars of expvar, so that each server of
+// the process reports its own ones.
+type eventMetrics struct {
+	vars *expvar.Map
+
+	eventsReceived *expvar.Int
+	eventsIgnored  *expvar.Int
+	eventsHandled  *expvar.Int
+	eventsFailed   *expvar.Int
+	eventsRunning  *expvar.Int
+
+	eventsPanicked    *expvar.Int
+	eventsQuarantined *expvar.Int
+
+	eventsRejected  *expvar.Int
+	eventsSpilled   *expvar.Int
+	eventsUnspilled *expvar.Int
+	queueDepth      *expvar.Int
+	queueDequeued   *expvar.Int
+	queueWaitTotal  *expvar.Int
+	queueWaitMax    *expvar.Int
+}
+
+func newEventMetrics() *eventMetrics {
+	m := &eventMetrics{
+		vars:           new(expvar.Map).Init(),
+		eventsReceived: new(expvar.Int),
+		eventsIgnored:  new(expvar.Int),
+		eventsHandled:  new(expvar.Int),
+		eventsFailed:   new(expvar.Int),
+		eventsRunning:  new(expvar.Int),
+
+		eventsPanicked:    new(expvar.Int),
+		eventsQuarantined: new(expvar.Int),
+
+		eventsRejected:  new(expvar.Int),
+		eventsSpilled:   new(expvar.Int),
+		eventsUnspilled: new(expvar.Int),
+		queueDepth:      new(expvar.Int),
+		queueDequeued:   new(expvar.Int),
+		queueWaitTotal:  new(expvar.Int),
+		queueWaitMax:    new(expvar.Int),
+	}
+
+	m.vars.Set("events_received", m.eventsReceived)
+	m.vars.Set("events_ignored", m.eventsIgnored)
+	m.vars.Set("events_handled", m.eventsHandled)
+	m.vars.Set("events_failed", m.eventsFailed)
+	m.vars.Set("events_running", m.eventsRunning)
+	m.vars.Set("events_panicked", m.eventsPanicked)
+	m.vars.Set("events_quarantined", m.eventsQuarantined)
+	m.vars.Set("events_rejected", m.eventsRejected)
+	m.vars.Set("events_spilled", m.eventsSpilled)
+	m.vars.Set("events_unspilled", m.eventsUnspilled)
+	m.vars.Set("queue_depth", m.queueDepth)
+	// the average wait is queue_wait_ms_total / queue_dequeued
+	m.vars.Set("queue_dequeued", m.queueDequeued)
+	m.vars.Set("queue_wait_ms_total", m.queueWaitTotal)
+	m.vars.Set("queue_wait_ms_max", m.queueWaitMax)
+
+	return m
+}
+
+// ServeHTTP writes the vars published by expvar in its format, together
+// with the ones of the server under the name of framework.
+func (m *eventMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "%q: %s\n}\n", "framework", m.vars)
+}
@@ -0,0 +1,91 @@
+package framework
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+)
+
+// PreHandleFunc is called before the handlers of an event. If it returns an
+// error, the event is not handled by any of them and the error is reported instead.
+type PreHandleFunc func(evt *GenericEvent, cnf config.Config, lgr *logrus.Entry) error
+
+// PostHandleFunc is called after the handlers of an event with the errors they
+// returned joined and how long the handling took, including the inner middlewares.
+type PostHandleFunc func(evt *GenericEvent, cnf config.Config, lgr *logrus.Entry, err error, elapsed time.Duration)
+
+// Middleware intercepts the handling of events, either of Pre and Post can be nil.
+// They are called once per event, around all the handlers of it.
+//
+// The middlewares are layered in the order they are registered: Pre of the first
+// one is called first and Post of it last. Once a Pre fails, neither the handlers
+// nor the Pre of the inner middlewares is called, while the Post of the middlewares
+// whose Pre has been called still is, with the error of Pre.
+type Middleware struct {
+	Pre  PreHandleFunc
+	Post PostHandleFunc
+}
+
+type middlewareEntry struct {
+	m Middleware
+
+	// eventTypes is the Event-Type Value the middleware applies to, all if it is empty
+	eventTypes []int
+}
+
+func (e *middlewareEntry) applies(eventType int) bool {
+	if len(e.eventTypes) == 0 {
+		return true
+	}
+
+	for _, t := range e.eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// middlewaresOf returns the middlewares of eventType in the order they are registered.
+func (h *postEventHandler) middlewaresOf(eventType int) []Middleware {
+	var ans []Middleware
+	for i := range h.middlewares {
+		if e := &h.middlewares[i]; e.applies(eventType) {
+			ans = append(ans, e.m)
+		}
+	}
+
+	return ans
+}
+
+// Wrap returns next with the middleware layered around it. A panic of Pre or
+// next is recovered and passed to Post as the error, so that Post never takes
+// a panicking event for a handled one. The error is still told as a panic by
+// the dispatcher, so the event can be quarantined.
+func (m Middleware) Wrap(next ContextHandlerFunc) ContextHandlerFunc {
+	return func(ctx context.Context, evt *GenericEvent, cnf config.Config, lgr *logrus.Entry) (err error) {
+		var elapsed time.Duration
+
+		if m.Post != nil {
+			defer func() {
+				m.Post(evt, cnf, lgr, err, elapsed)
+			}()
+		}
+
+		if m.Pre != nil {
+			if err = callHandler(func() error { return m.Pre(evt, cnf, lgr) }); err != nil {
+				return err
+			}
+		}
+
+		start := time.Now()
+		err = callHandler(func() error { return next(ctx, evt, cnf, lgr) })
+		elapsed = time.Since(start)
+
+		return err
+	}
+}
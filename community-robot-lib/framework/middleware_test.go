@@ -0,0 +1,158 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+)
+
+func runPushHandlers(h *handlers) ([]error, error) {
+	return h.index()[PushEvent].run(context.Background(), &GenericEvent{}, nil, logrus.NewEntry(logrus.New()), false)
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	var gotErr error
+	var gotElapsed time.Duration
+
+	record := func(name string, preErr error) Middleware {
+		return Middleware{
+			Pre: func(*GenericEvent, config.Config, *logrus.Entry) error {
+				calls = append(calls, "pre-"+name)
+				return preErr
+			},
+			Post: func(_ *GenericEvent, _ config.Config, _ *logrus.Entry, err error, elapsed time.Duration) {
+				calls = append(calls, "post-"+name)
+				gotErr, gotElapsed = err, elapsed
+			},
+		}
+	}
+
+	handlerErr := errors.New("handler failed")
+	handler := func(*GenericEvent, config.Config, *logrus.Entry) error {
+		calls = append(calls, "handler")
+		time.Sleep(time.Millisecond)
+		return handlerErr
+	}
+
+	h := handlers{}
+	h.RegisterMiddleware(record("a", nil))
+	h.RegisterMiddleware(record("b", nil), PushEvent)
+	h.RegisterMiddleware(record("c", nil), IssueEvent)
+	h.RegisterPushCodeBranchTagHandler(handler)
+
+	if h.index()[IssueEvent] != nil {
+		t.Fatal("expected no handler of issue event")
+	}
+
+	if errs, rejected := runPushHandlers(&h); rejected != nil || errs[0] != handlerErr {
+		t.Fatalf("expected the error of handler, got %v and %v", errs, rejected)
+	}
+
+	expected := []string{"pre-a", "pre-b", "handler", "post-b", "post-a"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+	if !errors.Is(gotErr, handlerErr) || gotElapsed < time.Millisecond {
+		t.Errorf("unexpected error %v or duration %s passed to post", gotErr, gotElapsed)
+	}
+
+	// a failing pre stops the inner middlewares and the handler
+	calls = nil
+	preErr := errors.New("rejected")
+
+	h = handlers{}
+	h.RegisterMiddleware(record("a", nil))
+	h.RegisterMiddleware(record("b", preErr))
+	h.RegisterMiddleware(record("c", nil))
+	h.RegisterPushCodeBranchTagHandler(handler)
+
+	if errs, rejected := runPushHandlers(&h); rejected != preErr || errs[0] != nil {
+		t.Fatalf("expected the error of pre, got %v and %v", errs, rejected)
+	}
+
+	expected = []string{"pre-a", "pre-b", "post-b", "post-a"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+	if gotErr != preErr {
+		t.Errorf("unexpected error %v passed to post", gotErr)
+	}
+}
+
+func TestMiddlewareOfPanickingHandler(t *testing.T) {
+	var gotErr error
+	var gotElapsed time.Duration
+
+	h := handlers{}
+	h.RegisterMiddleware(Middleware{
+		Post: func(_ *GenericEvent, _ config.Config, _ *logrus.Entry, err error, elapsed time.Duration) {
+			gotErr, gotElapsed = err, elapsed
+		},
+	})
+	h.RegisterPushCodeBranchTagHandler(func(*GenericEvent, config.Config, *logrus.Entry) error {
+		time.Sleep(time.Millisecond)
+		panic("boom")
+	})
+
+	errs, _ := runPushHandlers(&h)
+	if _, ok := errs[0].(*panicError); !ok {
+		t.Fatalf("expected the panic to be returned as the error, got %v", errs[0])
+	}
+
+	var pe *panicError
+	if !errors.As(gotErr, &pe) || gotElapsed < time.Millisecond {
+		t.Errorf("expected post to see the panic, got %v after %s", gotErr, gotElapsed)
+	}
+}
+
+func TestMiddlewareOfSeveralHandlers(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		var pre, post int32
+		var gotErr error
+
+		h := handlers{}
+		h.RegisterMiddleware(Middleware{
+			Pre: func(*GenericEvent, config.Config, *logrus.Entry) error {
+				atomic.AddInt32(&pre, 1)
+				return nil
+			},
+			Post: func(_ *GenericEvent, _ config.Config, _ *logrus.Entry, err error, _ time.Duration) {
+				atomic.AddInt32(&post, 1)
+				gotErr = err
+			},
+		})
+
+		errA, errB := errors.New("a failed"), errors.New("b failed")
+		var called int32
+		for _, err := range []error{errA, nil, errB} {
+			err := err
+			h.RegisterPushCodeBranchTagHandler(func(*GenericEvent, config.Config, *logrus.Entry) error {
+				atomic.AddInt32(&called, 1)
+				return err
+			})
+		}
+
+		errs, rejected := h.index()[PushEvent].run(
+			context.Background(), &GenericEvent{}, nil, logrus.NewEntry(logrus.New()), parallel,
+		)
+		if rejected != nil || called != 3 || errs[0] != errA || errs[1] != nil || errs[2] != errB {
+			t.Fatalf("parallel=%v: unexpected errors %v and %v of %d handlers", parallel, errs, rejected, called)
+		}
+
+		if pre != 1 || post != 1 {
+			t.Errorf("parallel=%v: expected pre and post to be called once per event, got %d and %d", parallel, pre, post)
+		}
+
+		if !errors.Is(gotErr, errA) || !errors.Is(gotErr, errB) {
+			t.Errorf("parallel=%v: expected post to see the errors of every handler, got %v", parallel, gotErr)
+		}
+	}
+}
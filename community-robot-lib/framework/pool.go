@@ -0,0 +1,188 @@
+package framework
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errQueueFull is returned by Dispatch when the event is rejected,
+// the webhook is replied with 503 so that the platform retries it.
+var errQueueFull = errors.New("event queue is full")
+
+type poolJob struct {
+	run      func()
+	queuedAt time.Time
+}
+
+// workerPool handles the events by a fixed number of workers. The events wait
+// in a bounded queue, a place in which is reserved before the event is accepted.
+type workerPool struct {
+	jobs     chan poolJob
+	capacity int64
+	queued   int64
+	metrics  *eventMetrics
+}
+
+func newWorkerPool(workers, queueSize int, metrics *eventMetrics) *workerPool {
+	p := &workerPool{
+		jobs:     make(chan poolJob, queueSize),
+		capacity: int64(queueSize),
+		metrics:  metrics,
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// reserve takes a place in the queue, it returns false if the queue is full.
+func (p *workerPool) reserve() bool {
+	if atomic.AddInt64(&p.queued, 1) > p.capacity {
+		atomic.AddInt64(&p.queued, -1)
+
+		return false
+	}
+
+	p.metrics.queueDepth.Add(1)
+
+	return true
+}
+
+// release gives back a place which is reserved but not used.
+func (p *workerPool) release() {
+	atomic.AddInt64(&p.queued, -1)
+	p.metrics.queueDepth.Add(-1)
+}
+
+// submit queues the job, a place must have been reserved for it,
+// so that it never blocks.
+func (p *workerPool) submit(job poolJob) {
+	p.jobs <- job
+}
+
+func (p *workerPool) work() {
+	for job := range p.jobs {
+		p.release()
+
+		wait := time.Since(job.queuedAt).Milliseconds()
+		p.metrics.queueWaitTotal.Add(wait)
+		p.metrics.queueDequeued.Add(1)
+		if wait > p.metrics.queueWaitMax.Value() {
+			p.metrics.queueWaitMax.Set(wait)
+		}
+
+		job.run()
+	}
+}
+
+const (
+	spillExt = ".event"
+
+	// spillExtGob is the extension of the events spilled before they were
+	// encoded with eventpb, they are still read.
+	spillExtGob = ".gob"
+)
+
+// spillStore keeps the events which overflow the queue on disk, they
+// are fed back to the queue in the order they are saved once it has room.
+type spillStore struct {
+	dir string
+
+	mut sync.Mutex
+	seq uint64
+}
+
+func newSpillStore(dir string) (*spillStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &spillStore{dir: dir}, nil
+}
+
+func (s *spillStore) save(evt *GenericEvent) error {
+	// proto, so that the spilled events outlive the renaming of fields
+	b, err := evt.Encode(EventFormatProto)
+	if err != nil {
+		return err
+	}
+
+	s.mut.Lock()
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spillExt)
+	s.mut.Unlock()
+
+	// written aside and renamed, so that pop never sees a partial file
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+// pop removes the oldest event from the store, it returns nil if there is none.
+func (s *spillStore) pop() (*GenericEvent, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var files []string
+	for _, ext := range []string{spillExt, spillExtGob} {
+		v, err := filepath.Glob(filepath.Join(s.dir, "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, v...)
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(files)
+
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(files[0]); err != nil {
+		return nil, err
+	}
+
+	evt := new(GenericEvent)
+	if err := evt.ConvertFromBytes(b); err != nil {
+		return nil, fmt.Errorf("%s: %v", files[0], err)
+	}
+
+	return evt, nil
+}
+
+// refill moves the spilled events back to the queue as long as it has room.
+func (d *dispatcher) refill() {
+	for d.pool.reserve() {
+		evt, err := d.spill.pop()
+		if err != nil || evt == nil {
+			d.pool.release()
+
+			if err != nil {
+				logrus.WithError(err).Error("Error reading spilled event.")
+			}
+
+			return
+		}
+
+		d.metrics.eventsUnspilled.Add(1)
+		d.enqueue(evt, logrus.WithFields(evt.CollectLogFiled()))
+	}
+}
@@ -0,0 +1,116 @@
+package framework
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+)
+
+func newPoolDispatcher(t *testing.T, spillDir string) (*dispatcher, chan struct{}, *int32) {
+	release := make(chan struct{})
+	var handled int32
+
+	h := handlers{}
+	h.RegisterPushCodeBranchTagHandler(func(*GenericEvent, config.Config, *logrus.Entry) error {
+		<-release
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+
+	d := &dispatcher{
+		agent:    new(config.ConfigAgent),
+		handlers: h.index(),
+		metrics:  newEventMetrics(),
+	}
+	d.pool = newWorkerPool(1, 1, d.metrics)
+
+	if spillDir != "" {
+		spill, err := newSpillStore(spillDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.spill = spill
+	}
+
+	return d, release, &handled
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkerPoolRejects(t *testing.T) {
+	d, release, handled := newPoolDispatcher(t, "")
+	lgr := logrus.NewEntry(logrus.New())
+	evt := func() *GenericEvent {
+		return &GenericEvent{EventHeader: EventHeader{EventType: PushEvent}}
+	}
+
+	if err := d.Dispatch(evt(), lgr); err != nil {
+		t.Fatal(err)
+	}
+	// the first event is taken by the only worker, the second one waits in the queue
+	waitFor(t, func() bool { return d.metrics.queueDepth.Value() == 0 })
+
+	if err := d.Dispatch(evt(), lgr); err != nil {
+		t.Fatal(err)
+	}
+	if v := d.metrics.queueDepth.Value(); v != 1 {
+		t.Fatalf("expected queue depth 1, got %d", v)
+	}
+
+	if err := d.Dispatch(evt(), lgr); !errors.Is(err, errQueueFull) {
+		t.Fatalf("expected the event to be rejected, got %v", err)
+	}
+
+	close(release)
+	d.Wait()
+
+	if v := atomic.LoadInt32(handled); v != 2 {
+		t.Errorf("expected 2 events handled, got %d", v)
+	}
+	if v := d.metrics.queueDequeued.Value(); v != 2 {
+		t.Errorf("expected 2 events dequeued, got %d", v)
+	}
+}
+
+func TestWorkerPoolSpills(t *testing.T) {
+	d, release, handled := newPoolDispatcher(t, t.TempDir())
+	lgr := logrus.NewEntry(logrus.New())
+
+	for i := 0; i < 4; i++ {
+		e := &GenericEvent{EventHeader: EventHeader{EventType: PushEvent}}
+		if err := d.Dispatch(e, lgr); err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 0 {
+			waitFor(t, func() bool { return d.metrics.queueDepth.Value() == 0 })
+		}
+	}
+
+	if v := d.metrics.eventsSpilled.Value(); v != 2 {
+		t.Fatalf("expected 2 events spilled, got %d", v)
+	}
+
+	close(release)
+	waitFor(t, func() bool {
+		d.refill()
+		return atomic.LoadInt32(handled) == 4
+	})
+
+	if evt, err := d.spill.pop(); evt != nil || err != nil {
+		t.Errorf("expected no spilled event left, got %v, %v", evt, err)
+	}
+}
@@ -0,0 +1,333 @@
+package framework
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	AdminQuarantinePath = "/admin/quarantine/"
+
+	// maxPanicRecords bounds the number of events whose panics are counted,
+	// the counts are reset once it is reached.
+	maxPanicRecords = 10000
+)
+
+// QuarantinedEvent is an event which made the handlers panic repeatedly,
+// it is not handled any more until it is replayed by an admin.
+type QuarantinedEvent struct {
+	ID            string        `json:"id"`
+	Event         *GenericEvent `json:"event"`
+	Panics        int           `json:"panics"`
+	LastPanic     string        `json:"last_panic"`
+	Stack         string        `json:"stack"`
+	QuarantinedAt time.Time     `json:"quarantined_at"`
+}
+
+// panicError is the error of a handler which panicked.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.value)
+}
+
+// callHandler calls the handler and turns its panic into a panicError.
+func callHandler(call func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &panicError{value: v, stack: debug.Stack()}
+		}
+	}()
+
+	return call()
+}
+
+// quarantine counts the panics of each event and keeps the events which
+// panicked too many times. The events are saved in dir if it is set,
+// so that they survive the restart.
+type quarantine struct {
+	after int
+	size  int
+	dir   string
+
+	mut    sync.Mutex
+	panics map[string]int
+	events map[string]*QuarantinedEvent
+}
+
+func newQuarantine(after, size int, dir string) (*quarantine, error) {
+	q := &quarantine{
+		after:  after,
+		size:   size,
+		dir:    dir,
+		panics: make(map[string]int),
+		events: make(map[string]*QuarantinedEvent),
+	}
+
+	if dir == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		v := new(QuarantinedEvent)
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		q.events[v.ID] = v
+
+		// the files were named by the id before, they are renamed,
+		// so that remove finds them
+		if p := q.path(v.ID); f != p {
+			if err := os.Rename(f, p); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return q, nil
+}
+
+func (q *quarantine) enabled() bool {
+	return q != nil && q.after > 0
+}
+
+// eventID identifies the event across its redeliveries.
+func eventID(evt *GenericEvent) string {
+	if evt.EventUUID != "" {
+		return evt.EventUUID
+	}
+
+	b := evt.SourcePayload
+	if len(b) == 0 {
+		b, _ = json.Marshal(evt)
+	}
+
+	return fmt.Sprintf("%x", sha1.Sum(b))
+}
+
+func (q *quarantine) has(id string) bool {
+	if !q.enabled() {
+		return false
+	}
+
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	_, ok := q.events[id]
+
+	return ok
+}
+
+// recordPanic counts the panic of the event, and quarantines the event
+// once it panicked too many times. It returns whether the event is quarantined.
+func (q *quarantine) recordPanic(evt *GenericEvent, e *panicError) (bool, error) {
+	if !q.enabled() {
+		return false, nil
+	}
+
+	id := eventID(evt)
+
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if len(q.panics) >= maxPanicRecords {
+		q.panics = make(map[string]int)
+	}
+
+	q.panics[id]++
+	n := q.panics[id]
+	if n < q.after {
+		return false, nil
+	}
+
+	if len(q.events) >= q.size {
+		return false, fmt.Errorf("quarantine is full")
+	}
+
+	v := &QuarantinedEvent{
+		ID:            id,
+		Event:         evt,
+		Panics:        n,
+		LastPanic:     fmt.Sprint(e.value),
+		Stack:         string(e.stack),
+		QuarantinedAt: time.Now(),
+	}
+
+	if err := q.save(v); err != nil {
+		return false, err
+	}
+
+	q.events[id] = v
+	delete(q.panics, id)
+
+	return true, nil
+}
+
+func (q *quarantine) save(v *QuarantinedEvent) error {
+	if q.dir == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(q.path(v.ID), b, 0o644)
+}
+
+// path returns the file of the event. The id comes from the sender of the
+// webhook, so the file is named by its hash to stay inside dir.
+func (q *quarantine) path(id string) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(id))))
+}
+
+func (q *quarantine) list() []*QuarantinedEvent {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	ans := make([]*QuarantinedEvent, 0, len(q.events))
+	for _, v := range q.events {
+		ans = append(ans, v)
+	}
+
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].QuarantinedAt.After(ans[j].QuarantinedAt)
+	})
+
+	return ans
+}
+
+func (q *quarantine) get(id string) *QuarantinedEvent {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	return q.events[id]
+}
+
+// remove releases the event from the quarantine and returns it.
+func (q *quarantine) remove(id string) (*QuarantinedEvent, error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	v, ok := q.events[id]
+	if !ok {
+		return nil, fmt.Errorf("no quarantined event %s", id)
+	}
+
+	if q.dir != "" {
+		if err := os.Remove(q.path(id)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	delete(q.events, id)
+
+	return v, nil
+}
+
+// restore puts back the event which failed to be replayed.
+func (q *quarantine) restore(v *QuarantinedEvent) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if err := q.save(v); err != nil {
+		logrus.WithError(err).Error("Error saving quarantined event.")
+	}
+
+	q.events[v.ID] = v
+}
+
+// quarantineHandler serves the quarantined events, the paths are relative to
+// where the handler is mounted, so it is expected to be wrapped by http.StripPrefix.
+//
+//	GET  events          list the quarantined events, the latest first
+//	GET  event?id=id     an event with the stack of its last panic
+//	POST replay?id=id    release the event and handle it again
+//	POST delete?id=id    release the event without handling it
+func (d *dispatcher) quarantineHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := strings.Trim(r.URL.Path, "/")
+
+		method := http.MethodGet
+		if action == "replay" || action == "delete" {
+			method = http.MethodPost
+		}
+
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+
+		switch action {
+		case "events":
+			writeJSON(w, d.quarantine.list())
+
+		case "event":
+			v := d.quarantine.get(id)
+			if v == nil {
+				http.Error(w, "no quarantined event "+id, http.StatusNotFound)
+				return
+			}
+			writeJSON(w, v)
+
+		case "replay", "delete":
+			v, err := d.quarantine.remove(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			if action == "replay" {
+				lgr := logrus.WithFields(v.Event.CollectLogFiled())
+				lgr.Info("Replaying quarantined event.")
+
+				if err := d.Dispatch(v.Event, lgr); err != nil {
+					d.quarantine.restore(v)
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+			}
+			writeJSON(w, map[string]string{action: id})
+
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,147 @@
+package framework
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+)
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+
+	var calls int32
+	h := handlers{}
+	h.RegisterIssueHandler(func(*GenericEvent, config.Config, *logrus.Entry) error {
+		atomic.AddInt32(&calls, 1)
+		panic("boom")
+	})
+
+	q, err := newQuarantine(2, 10, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &dispatcher{
+		agent:      new(config.ConfigAgent),
+		handlers:   h.index(),
+		metrics:    newEventMetrics(),
+		quarantine: q,
+	}
+
+	evt := &GenericEvent{EventHeader: EventHeader{EventType: IssueEvent, EventUUID: "uuid-1"}}
+	lgr := logrus.NewEntry(logrus.New())
+
+	for i := 0; i < 3; i++ {
+		d.Dispatch(evt, lgr)
+		d.Wait()
+	}
+
+	if v := atomic.LoadInt32(&calls); v != 2 {
+		t.Fatalf("expected the event to be handled twice before quarantined, got %d", v)
+	}
+	if d.metrics.eventsQuarantined.Value() != 1 {
+		t.Fatal("expected the event to be quarantined")
+	}
+
+	// the quarantine survives the restart
+	q, err = newQuarantine(2, 10, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := q.get("uuid-1"); v == nil || v.LastPanic != "boom" || v.Stack == "" {
+		t.Fatalf("expected the saved event, got %+v", v)
+	}
+
+	srv := http.StripPrefix("/admin/quarantine", d.quarantineHandler())
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/quarantine/events", nil))
+
+	var list []QuarantinedEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].ID != "uuid-1" {
+		t.Fatalf("unexpected list of quarantined events: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/quarantine/replay?id=uuid-1", nil))
+	d.Wait()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("replay failed: %d %s", w.Code, w.Body.String())
+	}
+	if v := atomic.LoadInt32(&calls); v != 3 {
+		t.Errorf("expected the replayed event to be handled, got %d calls", v)
+	}
+	if len(d.quarantine.list()) != 0 {
+		t.Error("expected the replayed event to be released")
+	}
+	if _, err := os.Stat(d.quarantine.path("uuid-1")); !os.IsNotExist(err) {
+		t.Errorf("expected the saved event to be removed, got %v", err)
+	}
+}
+
+func TestQuarantinePathOfUnsafeID(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "quarantine")
+
+	q, err := newQuarantine(1, 10, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	victim := filepath.Join(root, "victim.json")
+	if err := os.WriteFile(victim, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"../victim", "../../x", `..\victim`, "a/b"} {
+		if p := q.path(id); filepath.Dir(p) != dir {
+			t.Errorf("%s: expected the file in the quarantine dir, got %s", id, p)
+		}
+
+		evt := &GenericEvent{EventHeader: EventHeader{EventType: IssueEvent, EventUUID: id}}
+		if ok, err := q.recordPanic(evt, &panicError{value: "boom"}); !ok || err != nil {
+			t.Fatalf("%s: expected the event to be quarantined, got %v", id, err)
+		}
+
+		if _, err := q.remove(id); err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+	}
+
+	if _, err := os.Stat(victim); err != nil {
+		t.Errorf("expected the file outside the quarantine dir to be kept, got %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(root, "*.json")); len(files) != 1 {
+		t.Errorf("expected nothing written outside the quarantine dir, got %v", files)
+	}
+}
+
+func TestQuarantineRenamesLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+
+	b, _ := json.Marshal(&QuarantinedEvent{ID: "uuid-1", Event: new(GenericEvent)})
+	if err := os.WriteFile(filepath.Join(dir, "uuid-1.json"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := newQuarantine(1, 10, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.remove("uuid-1"); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 0 {
+		t.Errorf("expected the legacy file to be removed, got %v", files)
+	}
+}
@@ -2,17 +2,23 @@ package framework
 
 import (
 	"community-robot-lib/options"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"community-robot-lib/config"
 	"community-robot-lib/interrupts"
+	"community-robot-lib/secret"
+	"community-robot-lib/utils"
 )
 
 type HandlerRegister interface {
 	RegisterPreEventHandler(PreEventHandlerFunc)
+	RegisterMiddleware(m Middleware, eventTypes ...int)
 	RegisterAccessHandler(GenericHandlerFunc)
 	RegisterPushCodeBranchTagHandler(GenericHandlerFunc)
 	RegisterIssueHandler(GenericHandlerFunc)
@@ -20,6 +26,14 @@ type HandlerRegister interface {
 	RegisterIssueCommentHandler(GenericHandlerFunc)
 	RegisterPullRequestCommentHandler(GenericHandlerFunc)
 	RegisterOtherHandler(GenericHandlerFunc)
+	RegisterReleaseHandler(GenericHandlerFunc)
+	RegisterTagHandler(GenericHandlerFunc)
+	RegisterPullRequestReviewHandler(GenericHandlerFunc)
+	RegisterPipelineHandler(GenericHandlerFunc)
+	RegisterMemberHandler(GenericHandlerFunc)
+	RegisterRepoSettingsHandler(GenericHandlerFunc)
+	RegisterHandler(eventType int, fn GenericHandlerFunc)
+	RegisterContextHandler(eventType int, fn ContextHandlerFunc)
 }
 
 type Robot interface {
@@ -27,45 +41,271 @@ type Robot interface {
 	RegisterEventHandler(HandlerRegister)
 }
 
-func Run(bot Robot, servOpt options.ServiceOptions, clientOpt options.ClientOptions) {
-	agent := config.NewConfigAgent(bot.NewConfig)
-	if err := agent.Start(servOpt.ConfigFile); err != nil {
-		logrus.WithError(err).Errorf("start config:%s", servOpt.ConfigFile)
-		return
+// ConfigChangeHandler is implemented by the robots which need to react
+// as soon as a new config is loaded, old is nil on the first load.
+type ConfigChangeHandler interface {
+	OnConfigChange(old, new config.Config)
+}
+
+// EventSource is implemented by the robots which produce events by themselves
+// besides the ones coming from webhook. StartEventSource is called once the
+// dispatcher is ready, the events passed to emit are handled the same way as
+// the ones coming from webhook.
+type EventSource interface {
+	StartEventSource(emit func(*GenericEvent), getConfig func() config.Config)
+}
+
+// ShutdownHandler is implemented by the robots which keep work of their own
+// besides the handlers, such as the events held back to be delivered later.
+// Shutdown is called on interrupt once the handlers have finished, and it
+// is expected to block until the work is done or dropped.
+type ShutdownHandler interface {
+	Shutdown()
+}
+
+// Server hosts a robot. It owns the handlers, the muxes, the dispatcher
+// and the config agent of the robot, so that several robots can be hosted
+// in one process and each one can be tested in isolation.
+type Server struct {
+	bot       Robot
+	servOpt   options.ServiceOptions
+	clientOpt options.ClientOptions
+
+	agent   config.ConfigAgent
+	d       *dispatcher
+	mux     *http.ServeMux
+	admin   *http.ServeMux
+	metrics *eventMetrics
+	certs   *secret.CertReloader
+}
+
+// NewServer creates the server of bot, the handlers of the robot are registered
+// at once, while the config is not loaded and nothing is served until Start.
+func NewServer(bot Robot, servOpt options.ServiceOptions, clientOpt options.ClientOptions) *Server {
+	s := &Server{
+		bot:       bot,
+		servOpt:   servOpt,
+		clientOpt: clientOpt,
+		agent:     config.NewConfigAgent(bot.NewConfig),
+		mux:       http.NewServeMux(),
+		metrics:   newEventMetrics(),
 	}
 
-	defer interrupts.WaitForGracefulShutdown()
+	s.admin = newAdminMux(s.mux, &s.agent, s.metrics, servOpt)
 
 	// dispatcher not used, custom handle request
-	if clientOpt.Handler == nil {
-		h := handlers{}
-		bot.RegisterEventHandler(&h)
-		buildDispatcherHandler(&h)
-		d := &dispatcher{agent: &agent, h: h, hmac: clientOpt.TokenGenerator}
+	if clientOpt.Handler != nil {
+		return s
+	}
+
+	h := handlers{}
+	bot.RegisterEventHandler(&h)
+
+	s.d = &dispatcher{
+		agent:    &s.agent,
+		h:        h,
+		handlers: h.index(),
+		parallel: servOpt.ParallelHandlers,
+		timeout:  servOpt.HandlerTimeout,
+		metrics:  s.metrics,
+		hmac:     clientOpt.TokenGenerator,
+	}
+	if servOpt.OrderedDispatch {
+		s.d.serial = utils.NewKeyedExecutor()
+	}
+
+	if servOpt.Workers > 0 {
+		s.d.pool = newWorkerPool(servOpt.Workers, servOpt.QueueSize, s.metrics)
+	}
+
+	if servOpt.QueueFullPolicy == options.QueueFullSpill {
+		spill, err := newSpillStore(servOpt.SpillDir)
+		if err != nil {
+			logrus.WithError(err).Error("Error opening spill dir, the events are rejected when the queue is full.")
+		} else {
+			s.d.spill = spill
+		}
+	}
+
+	q, err := newQuarantine(servOpt.QuarantineAfter, servOpt.QuarantineSize, servOpt.QuarantineDir)
+	if err != nil {
+		logrus.WithError(err).Error("Error loading quarantine, the quarantined events are kept in memory only.")
+
+		q, _ = newQuarantine(servOpt.QuarantineAfter, servOpt.QuarantineSize, "")
+	}
+	s.d.quarantine = q
+
+	if servOpt.AdminTokenGenerator != nil {
+		s.admin.Handle(AdminQuarantinePath, requireAdmin(
+			http.StripPrefix(strings.TrimSuffix(AdminQuarantinePath, "/"), s.d.quarantineHandler()), servOpt,
+		))
+	}
+
+	// the probes of the robots check the health on the public listener,
+	// so it keeps answering every path even if there is an admin listener
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// service's healthy check, do nothing
+	})
+
+	s.mux.Handle(clientOpt.HandlerPath, s.d)
+
+	return s
+}
+
+// Handle registers h on the public listener, which the webhooks are served on.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// HandleAdmin registers h on the admin listener.
+func (s *Server) HandleAdmin(pattern string, h http.Handler) {
+	s.admin.Handle(pattern, h)
+}
+
+// Handler returns the handler of the public listener.
+func (s *Server) Handler() http.Handler {
+	if s.clientOpt.Handler != nil {
+		return s.clientOpt.Handler
+	}
+
+	return s.mux
+}
+
+// AdminHandler returns the handler of the admin listener.
+func (s *Server) AdminHandler() http.Handler {
+	return s.admin
+}
+
+// Dispatch handles the event the same way as the ones coming from webhook,
+// it fails if the event is rejected because the queue is full.
+func (s *Server) Dispatch(e *GenericEvent) error {
+	if s.d == nil {
+		return nil
+	}
+
+	return s.d.Dispatch(e, logrus.WithFields(e.CollectLogFiled()))
+}
+
+// Start loads the config and starts the listeners, it doesn't block. Both the
+// listeners and the config agent are stopped on interrupt, wait for them by
+// interrupts.WaitForGracefulShutdown.
+func (s *Server) Start() error {
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	if s.d != nil {
+		// the handlers are told as soon as the shutdown starts
+		s.d.ctx = interrupts.Context()
+
+		if s.d.pool != nil && s.d.spill != nil {
+			// the events spilled before the restart are picked up as well
+			interrupts.TickLiteral(s.d.refill, time.Second)
+		}
 
 		interrupts.OnInterrupt(func() {
-			agent.Stop()
-			d.Wait()
-		})
+			s.agent.Stop()
+			s.d.Wait()
 
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			// service's healthy check, do nothing
+			if sh, ok := s.bot.(ShutdownHandler); ok {
+				sh.Shutdown()
+			}
 		})
 
-		http.Handle(clientOpt.HandlerPath, d)
+		if es, ok := s.bot.(EventSource); ok {
+			es.StartEventSource(func(e *GenericEvent) {
+				if err := s.Dispatch(e); err != nil {
+					logrus.WithFields(e.CollectLogFiled()).WithError(err).Error("Error dispatching event.")
+				}
+			}, s.d.getConfig)
+		}
 	} else {
-		interrupts.OnInterrupt(func() {
-			agent.Stop()
+		interrupts.OnInterrupt(s.agent.Stop)
+	}
+
+	s.serve(&http.Server{
+		Addr:         ":" + strconv.Itoa(s.servOpt.Port),
+		Handler:      s.Handler(),
+		ReadTimeout:  s.servOpt.ReadTimeout,
+		WriteTimeout: s.servOpt.WriteTimeout,
+		IdleTimeout:  s.servOpt.IdleTimeout,
+	})
+
+	if s.servOpt.AdminPort != 0 {
+		s.serve(&http.Server{
+			Addr:         ":" + strconv.Itoa(s.servOpt.AdminPort),
+			Handler:      s.admin,
+			ReadTimeout:  s.servOpt.AdminReadTimeout,
+			WriteTimeout: s.servOpt.AdminWriteTimeout,
+			IdleTimeout:  s.servOpt.AdminIdleTimeout,
 		})
 	}
 
-	httpServer := &http.Server{
-		Addr:         ":" + strconv.Itoa(servOpt.Port),
-		Handler:      clientOpt.Handler,
-		ReadTimeout:  servOpt.ReadTimeout,
-		WriteTimeout: servOpt.WriteTimeout,
-		IdleTimeout:  servOpt.IdleTimeout,
+	return nil
+}
+
+// load starts the config agent and loads the certificate.
+func (s *Server) load() error {
+	if s.servOpt.ConfigUnknownFields != "" {
+		if err := s.agent.SetUnknownFieldsMode(s.servOpt.ConfigUnknownFields); err != nil {
+			return fmt.Errorf("set mode of unknown fields: %v", err)
+		}
+	}
+
+	if h, ok := s.bot.(ConfigChangeHandler); ok {
+		s.agent.OnChange(h.OnConfigChange)
+	}
+
+	src := config.NewConfigSource(s.servOpt.ConfigFile)
+	if s.servOpt.ConfigEnvPrefix != "" {
+		src = config.WithEnvOverlay(src, s.servOpt.ConfigEnvPrefix)
+	}
+
+	if err := s.agent.StartSource(src); err != nil {
+		return fmt.Errorf("start config:%s: %v", s.servOpt.ConfigFile, err)
+	}
+
+	if !s.servOpt.TLSEnabled() {
+		return nil
+	}
+
+	s.certs = secret.NewCertReloader(s.servOpt.TLSCertFile, s.servOpt.TLSKeyFile, s.servOpt.TLSClientCAFile)
+	if err := s.certs.Start(); err != nil {
+		s.agent.Stop()
+
+		return fmt.Errorf("start tls: %v", err)
+	}
+
+	interrupts.OnInterrupt(s.certs.Stop)
+
+	return nil
+}
+
+// serve runs the server until interrupted, over TLS if there is a certificate.
+func (s *Server) serve(hs *http.Server) {
+	if s.certs == nil {
+		interrupts.ListenAndServe(hs, s.servOpt.GracePeriod)
+
+		return
+	}
+
+	// the certificate comes from TLSConfig, so that it is reloaded without a restart
+	hs.TLSConfig = s.certs.TLSConfig()
+	interrupts.ListenAndServeTLS(hs, "", "", s.servOpt.GracePeriod)
+}
+
+// Run starts the server and blocks until it is shut down.
+func (s *Server) Run() {
+	if err := s.Start(); err != nil {
+		logrus.WithError(err).Error("start server")
+
+		return
 	}
 
-	interrupts.ListenAndServe(httpServer, servOpt.GracePeriod)
+	interrupts.WaitForGracefulShutdown()
+}
+
+// Run hosts bot until it is shut down, see Server.
+func Run(bot Robot, servOpt options.ServiceOptions, clientOpt options.ClientOptions) {
+	NewServer(bot, servOpt, clientOpt).Run()
 }
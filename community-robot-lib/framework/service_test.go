@@ -0,0 +1,158 @@
+package framework
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/config"
+	"community-robot-lib/options"
+)
+
+type testRobot struct {
+	name string
+
+	mut     sync.Mutex
+	handled []string
+	done    chan struct{}
+}
+
+func (bot *testRobot) NewConfig() config.Config {
+	return nil
+}
+
+func (bot *testRobot) RegisterEventHandler(f HandlerRegister) {
+	f.RegisterPreEventHandler(func(w http.ResponseWriter, r *http.Request) *GenericEvent {
+		return &GenericEvent{
+			EventHeader:  EventHeader{EventType: PushEvent},
+			EventPayload: EventPayload{Org: "org", Repo: r.URL.Query().Get("repo")},
+		}
+	})
+
+	f.RegisterPushCodeBranchTagHandler(func(evt *GenericEvent, _ config.Config, _ *logrus.Entry) error {
+		bot.mut.Lock()
+		bot.handled = append(bot.handled, bot.name+":"+evt.Repo)
+		bot.mut.Unlock()
+
+		bot.done <- struct{}{}
+
+		return nil
+	})
+}
+
+func TestServersAreIsolated(t *testing.T) {
+	a := &testRobot{name: "a", done: make(chan struct{}, 1)}
+	b := &testRobot{name: "b", done: make(chan struct{}, 1)}
+
+	sa := NewServer(a, options.ServiceOptions{}, options.ClientOptions{HandlerPath: "/a-hook"})
+	sb := NewServer(b, options.ServiceOptions{}, options.ClientOptions{HandlerPath: "/b-hook"})
+
+	post := func(s *Server, path string) int {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+
+		return w.Code
+	}
+
+	post(sa, "/a-hook?repo=r1")
+	post(sb, "/b-hook?repo=r2")
+
+	for _, bot := range []*testRobot{a, b} {
+		select {
+		case <-bot.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("robot %s handled nothing", bot.name)
+		}
+	}
+
+	if len(a.handled) != 1 || a.handled[0] != "a:r1" {
+		t.Errorf("unexpected events of robot a: %v", a.handled)
+	}
+	if len(b.handled) != 1 || b.handled[0] != "b:r2" {
+		t.Errorf("unexpected events of robot b: %v", b.handled)
+	}
+
+	// the hook of a robot is not served by the other one
+	if v := sa.metrics.eventsReceived.Value(); v != 1 {
+		t.Errorf("expected 1 event received by a, got %d", v)
+	}
+	post(sa, "/b-hook?repo=r3")
+	if v := sa.metrics.eventsReceived.Value(); v != 1 {
+		t.Errorf("expected the hook of b not to reach a, got %d events", v)
+	}
+}
+
+func TestPublicHealthCheck(t *testing.T) {
+	for _, adminPort := range []int{0, 8823} {
+		s := NewServer(&testRobot{}, options.ServiceOptions{AdminPort: adminPort}, options.ClientOptions{HandlerPath: "/hook"})
+
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("admin port %d: expected the public health check, got %d", adminPort, w.Code)
+		}
+	}
+}
+
+func TestAdminWithoutAdminListener(t *testing.T) {
+	get := func(adminPort int, path string) int {
+		s := NewServer(&testRobot{}, options.ServiceOptions{
+			AdminPort:           adminPort,
+			AdminTokenGenerator: func() []byte { return []byte("admin") },
+		}, options.ClientOptions{HandlerPath: "/hook"})
+
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		return w.Code
+	}
+
+	// the public listener answers every path, so the admin endpoints are told
+	// by their own answers: metrics by 200 and the others by 401 without token
+	cases := map[string]int{MetricsPath: http.StatusOK, AdminConfigPath + "pin": http.StatusUnauthorized, AdminQuarantinePath + "events": http.StatusUnauthorized}
+	for path, code := range cases {
+		if v := get(0, path); v != code {
+			t.Errorf("%s: expected %d on the public listener without admin listener, got %d", path, code, v)
+		}
+	}
+
+	if v := get(8823, AdminConfigPath+"pin"); v != http.StatusOK {
+		t.Errorf("expected the admin endpoints apart from the public listener, got %d", v)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	get := func(s *Server, token string) int {
+		r := httptest.NewRequest(http.MethodGet, AdminConfigPath+"pin", nil)
+		r.Header.Set("Authorization", bearerPrefix+token)
+
+		w := httptest.NewRecorder()
+		s.AdminHandler().ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	webhook := func() []byte { return []byte("webhook") }
+
+	s := NewServer(&testRobot{}, options.ServiceOptions{AdminPort: 8823}, options.ClientOptions{HandlerPath: "/hook", TokenGenerator: webhook})
+	if code := get(s, "webhook"); code != http.StatusNotFound {
+		t.Errorf("expected no admin endpoint without admin token, got %d", code)
+	}
+
+	s = NewServer(&testRobot{}, options.ServiceOptions{
+		AdminPort:           8823,
+		AdminTokenGenerator: func() []byte { return []byte("admin") },
+	}, options.ClientOptions{HandlerPath: "/hook", TokenGenerator: webhook})
+
+	if code := get(s, "webhook"); code != http.StatusUnauthorized {
+		t.Errorf("expected the webhook secret to be rejected, got %d", code)
+	}
+	if code := get(s, "admin"); code == http.StatusUnauthorized || code == http.StatusNotFound {
+		t.Errorf("expected the admin token to be accepted, got %d", code)
+	}
+}
@@ -5,8 +5,10 @@ go 1.20
 require (
 	//git-platform-sdk v0.0.0-00010101000000-000000000000
 	github.com/Shopify/sarama v1.34.1
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/google/uuid v1.3.0
 	github.com/sirupsen/logrus v1.9.3
+	google.golang.org/protobuf v1.34.2
 	k8s.io/apimachinery v0.29.1
 	sigs.k8s.io/yaml v1.3.0
 )
@@ -0,0 +1,122 @@
+package options
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FlagsFileFlag is the flag which ParseFlags reads the flags file from.
+const FlagsFileFlag = "flags-file"
+
+// EnvName returns the name of env var which the flag is bound to, it is the
+// upper snake case of the flag name following the prefix. For example, the flag
+// config-file with the prefix ROBOT_ is bound to ROBOT_CONFIG_FILE.
+func EnvName(prefix, flagName string) string {
+	return prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(flagName))
+}
+
+// ParseFlags parses args into fs, so that each flag can be set from three places
+// besides its default, the first one which sets it wins:
+//
+//  1. the command line
+//  2. the env var named by EnvName(envPrefix, flag)
+//  3. the flags file given by --flags-file, which has one name=value per line,
+//     the name can be led by dashes, and empty lines and lines starting with # are skipped
+//
+// The flag flags-file is added to fs if it is not defined.
+func ParseFlags(fs *flag.FlagSet, args []string, envPrefix string) error {
+	if fs.Lookup(FlagsFileFlag) == nil {
+		fs.String(FlagsFileFlag, "", "Path to a file of flags, one name=value per line.")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+
+		name := EnvName(envPrefix, f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			if err = fs.Set(f.Name, v); err != nil {
+				err = fmt.Errorf("env %s: %v", name, err)
+
+				return
+			}
+			set[f.Name] = true
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	path := fs.Lookup(FlagsFileFlag).Value.String()
+	if path == "" {
+		return nil
+	}
+
+	values, err := readFlagsFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range values {
+		if fs.Lookup(kv[0]) == nil {
+			return fmt.Errorf("%s: unknown flag %s", path, kv[0])
+		}
+
+		if set[kv[0]] {
+			continue
+		}
+
+		if err := fs.Set(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("%s: flag %s: %v", path, kv[0], err)
+		}
+	}
+
+	return nil
+}
+
+func readFlagsFile(path string) ([][2]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ans [][2]string
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			// a boolean flag can be given by its name only
+			k, v = line, "true"
+		}
+
+		k = strings.TrimLeft(strings.TrimSpace(k), "-")
+		if k == "" {
+			return nil, fmt.Errorf("%s:%d: missing name of flag", path, n)
+		}
+
+		ans = append(ans, [2]string{k, strings.TrimSpace(v)})
+	}
+
+	return ans, s.Err()
+}
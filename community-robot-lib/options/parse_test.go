@@ -0,0 +1,45 @@
+package options
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseFlags(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "flags")
+	content := "# service\n--port=9000\nconfig-file = /etc/config.yaml\ngrace-period=1m\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_CONFIG_FILE", "/env/config.yaml")
+	t.Setenv("TEST_READ_TIMEOUT", "5s")
+
+	var o ServiceOptions
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.AddFlags(fs)
+
+	args := []string{"--flags-file", file, "--grace-period", "2m"}
+	if err := ParseFlags(fs, args, "TEST_"); err != nil {
+		t.Fatal(err)
+	}
+
+	if o.Port != 9000 {
+		t.Errorf("expected port from flags file, got %d", o.Port)
+	}
+	if o.ConfigFile != "/env/config.yaml" {
+		t.Errorf("expected env to win over flags file, got %s", o.ConfigFile)
+	}
+	if o.GracePeriod != 2*time.Minute {
+		t.Errorf("expected command line to win, got %s", o.GracePeriod)
+	}
+	if o.ReadTimeout != 5*time.Second {
+		t.Errorf("expected read timeout from env, got %s", o.ReadTimeout)
+	}
+	if o.WriteTimeout != 180*time.Second {
+		t.Errorf("expected default write timeout, got %s", o.WriteTimeout)
+	}
+}
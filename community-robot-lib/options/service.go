@@ -4,6 +4,15 @@ import (
 	"flag"
 	"fmt"
 	"time"
+
+	"community-robot-lib/config"
+)
+
+const (
+	// QueueFullReject replies 503 to the webhooks, so that the platform retries them.
+	QueueFullReject = "reject"
+	// QueueFullSpill saves the events on disk, they are handled once the queue has room.
+	QueueFullSpill = "spill"
 )
 
 type ServiceOptions struct {
@@ -13,6 +22,65 @@ type ServiceOptions struct {
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
+
+	// AdminPort is the port of the listener of health, metrics and admin
+	// endpoints, which is kept apart from the public one of webhooks.
+	// If it is 0, they are served on the public listener instead.
+	AdminPort         int
+	AdminReadTimeout  time.Duration
+	AdminWriteTimeout time.Duration
+	AdminIdleTimeout  time.Duration
+
+	// AdminTokenPath is the file of the token which the admin endpoints
+	// require as a bearer token. The robot loads it by its secret agent into
+	// AdminTokenGenerator, the admin endpoints are not mounted without it.
+	AdminTokenPath      string
+	AdminTokenGenerator func() []byte
+
+	// OrderedDispatch makes the events of the same PR or issue be handled
+	// one after another in the order they come.
+	OrderedDispatch bool
+
+	// ParallelHandlers makes the handlers registered for the same event type
+	// run concurrently, instead of one after another in the order they are registered.
+	ParallelHandlers bool
+
+	// HandlerTimeout is the default time budget of handling an event,
+	// the handlers see it as the deadline of their context.
+	HandlerTimeout time.Duration
+
+	// QuarantineAfter is how many times an event can make the handlers panic
+	// before it is quarantined, the quarantine is disabled if it is 0. The
+	// quarantined events are saved in QuarantineDir if it is set.
+	QuarantineAfter int
+	QuarantineSize  int
+	QuarantineDir   string
+
+	// Workers is the number of events handled at the same time, the events
+	// wait in a queue of QueueSize. When the queue is full, the events are
+	// rejected or spilled to SpillDir according to QueueFullPolicy.
+	// Every event is handled at once by a goroutine of its own if it is 0.
+	Workers         int
+	QueueSize       int
+	QueueFullPolicy string
+	SpillDir        string
+
+	// ConfigEnvPrefix is the prefix of the env vars which override
+	// individual keys of config, see config.WithEnvOverlay.
+	ConfigEnvPrefix string
+
+	// ConfigUnknownFields is how the unknown fields of config are handled,
+	// either reject or warn.
+	ConfigUnknownFields string
+
+	// TLSCertFile and TLSKeyFile make the webhooks be served over TLS,
+	// the certificate is reloaded as soon as the files change.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSClientCAFile is the CA which the client certificates are verified
+	// against, see framework.RequireClientCert.
+	TLSClientCAFile string
 }
 
 func (o *ServiceOptions) Validate() error {
@@ -20,14 +88,91 @@ func (o *ServiceOptions) Validate() error {
 		return fmt.Errorf("missing config-file")
 	}
 
+	if o.ConfigUnknownFields != "" {
+		if err := config.ValidateUnknownFieldsMode(o.ConfigUnknownFields); err != nil {
+			return err
+		}
+	}
+
+	if o.HandlerTimeout < 0 {
+		return fmt.Errorf("handler-timeout can't be negative")
+	}
+
+	if o.QuarantineAfter < 0 {
+		return fmt.Errorf("quarantine-after can't be negative")
+	}
+
+	if o.QuarantineAfter > 0 && o.QuarantineSize <= 0 {
+		return fmt.Errorf("quarantine-size must be positive")
+	}
+
+	if o.Workers < 0 {
+		return fmt.Errorf("workers can't be negative")
+	}
+
+	if o.Workers > 0 && o.QueueSize <= 0 {
+		return fmt.Errorf("queue-size must be positive")
+	}
+
+	switch o.QueueFullPolicy {
+	case QueueFullReject, "":
+	case QueueFullSpill:
+		if o.SpillDir == "" {
+			return fmt.Errorf("spill-dir is required when queue-full-policy is spill")
+		}
+	default:
+		return fmt.Errorf("unknown queue-full-policy: %s", o.QueueFullPolicy)
+	}
+
+	if o.AdminPort < 0 {
+		return fmt.Errorf("admin-port can't be negative")
+	}
+
+	if o.AdminPort != 0 && o.AdminPort == o.Port {
+		return fmt.Errorf("admin-port must differ from port")
+	}
+
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		return fmt.Errorf("tls-cert-file and tls-key-file must be set together")
+	}
+
+	if o.TLSClientCAFile != "" && o.TLSCertFile == "" {
+		return fmt.Errorf("tls-client-ca-file requires tls-cert-file")
+	}
+
 	return nil
 }
 
+// TLSEnabled tells whether the webhooks are served over TLS.
+func (o *ServiceOptions) TLSEnabled() bool {
+	return o.TLSCertFile != ""
+}
+
 func (o *ServiceOptions) AddFlags(fs *flag.FlagSet) {
 	fs.IntVar(&o.Port, "port", 8888, "Port to listen on.")
-	fs.StringVar(&o.ConfigFile, "config-file", "", "Path to config file.")
+	fs.StringVar(&o.ConfigFile, "config-file", "", "Path to config file, or to a directory or a glob of config files which are merged, or a url of http(s) which is polled.")
+	fs.StringVar(&o.ConfigUnknownFields, "config-unknown-fields", config.UnknownFieldsReject, "How the unknown fields of config are handled: reject or warn.")
+	fs.StringVar(&o.ConfigEnvPrefix, "config-env-prefix", "", "Prefix of the env vars which override individual keys of config, such as ROBOT_CONFIG_.")
 	fs.DurationVar(&o.GracePeriod, "grace-period", 180*time.Second, "On shutdown, try to handle remaining events for the specified duration.")
 	fs.DurationVar(&o.ReadTimeout, "read-timeout", 180*time.Second, "the maximum duration for reading the entire request, including the body")
 	fs.DurationVar(&o.WriteTimeout, "write-timeout", 180*time.Second, "the maximum duration before timing out writes of the response")
 	fs.DurationVar(&o.IdleTimeout, "idle-timeout", 30*time.Minute, "the maximum amount of time to wait for the next request when keep-alives are enabled")
+	fs.IntVar(&o.AdminPort, "admin-port", 8889, "Port of the admin listener which serves health, metrics and admin endpoints, 0 serves them on port.")
+	fs.DurationVar(&o.AdminReadTimeout, "admin-read-timeout", 30*time.Second, "the maximum duration for reading the entire request to the admin listener")
+	fs.DurationVar(&o.AdminWriteTimeout, "admin-write-timeout", 30*time.Second, "the maximum duration before timing out writes of the response of the admin listener")
+	fs.DurationVar(&o.AdminIdleTimeout, "admin-idle-timeout", 5*time.Minute, "the maximum amount of time to wait for the next request to the admin listener")
+	fs.StringVar(&o.AdminTokenPath, "admin-token-path", "", "Path to the token which the admin endpoints require, they are disabled if it is empty. It must differ from the webhook secret.")
+	fs.StringVar(&o.TLSCertFile, "tls-cert-file", "", "Path to the certificate to serve over TLS, it is reloaded when the file changes.")
+	fs.StringVar(&o.TLSKeyFile, "tls-key-file", "", "Path to the private key of tls-cert-file.")
+	fs.StringVar(&o.TLSClientCAFile, "tls-client-ca-file", "", "Path to the CA which verifies client certificates, the endpoints of plugins and admin require one if it is set.")
+	fs.DurationVar(&o.HandlerTimeout, "handler-timeout", 0, "Default time budget of handling an event, 0 means no limit. The config can set it per event type.")
+	fs.IntVar(&o.Workers, "workers", 0, "Number of events handled at the same time, 0 means every event is handled at once.")
+	fs.IntVar(&o.QueueSize, "queue-size", 1000, "Maximum number of events waiting for a worker.")
+	fs.StringVar(&o.QueueFullPolicy, "queue-full-policy", QueueFullReject, "What to do with the events when the queue is full: reject (reply 503) or spill (save them in spill-dir).")
+	fs.StringVar(&o.SpillDir, "spill-dir", "", "Directory to save the events in when the queue is full and queue-full-policy is spill.")
+	fs.IntVar(&o.QuarantineAfter, "quarantine-after", 3, "Quarantine an event once it made the handlers panic this many times, 0 disables the quarantine.")
+	fs.IntVar(&o.QuarantineSize, "quarantine-size", 1000, "Maximum number of quarantined events.")
+	fs.StringVar(&o.QuarantineDir, "quarantine-dir", "", "Directory to save the quarantined events in, they are kept in memory only if it is empty.")
+	fs.BoolVar(&o.ParallelHandlers, "parallel-handlers", false, "Run the handlers of the same event type concurrently instead of in the order they are registered.")
+	fs.BoolVar(&o.OrderedDispatch, "ordered-dispatch", false, "Handle the events of the same PR or issue one after another in the order they come.")
 }
@@ -25,14 +25,13 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 
 	"community-robot-lib/logrusutil"
-	"community-robot-lib/utils"
 )
 
 // Agent watches a path and automatically loads the secrets stored.
 type Agent struct {
 	sync.RWMutex
 	secretsMap map[string][]byte
-	ss         []singleSecret
+	ss         []*singleSecret
 }
 
 // Start creates goroutines to monitor the files that contain the secret value.
@@ -76,10 +75,9 @@ func (a *Agent) Add(path string) error {
 }
 
 func (a *Agent) loadSingleSecret(path string) {
-	s := singleSecret{
-		l:         logrus.NewEntry(logrus.StandardLogger()),
+	s := &singleSecret{
+		l:         logrus.WithField("secret-path", path),
 		path:      path,
-		t:         utils.NewTimer(),
 		setSecret: a.setSecret,
 	}
 	s.start()
@@ -0,0 +1,134 @@
+package secret
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"community-robot-lib/utils"
+)
+
+// CertReloader serves a TLS certificate and optionally the CA of client
+// certificates, both are reloaded as soon as their files change, the same
+// way the Agent reloads the secrets. The files which fail to load are
+// logged and the ones loaded before keep being served.
+type CertReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	l  *logrus.Entry
+	ws []*utils.FileWatcher
+
+	mut      sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+}
+
+// NewCertReloader creates a reloader of the certificate. clientCAFile can be
+// empty, then client certificates are not requested.
+func NewCertReloader(certFile, keyFile, clientCAFile string) *CertReloader {
+	return &CertReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		l:            logrus.WithField("cert-path", certFile),
+	}
+}
+
+// Start loads the files and starts watching them.
+// If the first attempt fails, then Start returns the error.
+func (r *CertReloader) Start() error {
+	if err := r.load(); err != nil {
+		return err
+	}
+
+	for _, path := range sets.NewString(r.certFile, r.keyFile, r.clientCAFile).Delete("").List() {
+		w := utils.NewFileWatcher(path, r.reload, utils.DefaultWatchDebounce, pollInterval)
+		w.Start()
+		r.ws = append(r.ws, w)
+	}
+
+	return nil
+}
+
+func (r *CertReloader) Stop() {
+	for _, w := range r.ws {
+		w.Stop()
+	}
+}
+
+func (r *CertReloader) reload() {
+	if err := r.load(); err != nil {
+		r.l.WithError(err).Error("Error reloading certificate.")
+	}
+}
+
+func (r *CertReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading certificate: %v", err)
+	}
+
+	var pool *x509.CertPool
+	if r.clientCAFile != "" {
+		b, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", r.clientCAFile, err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificate is found in %s", r.clientCAFile)
+		}
+	}
+
+	r.mut.Lock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.mut.Unlock()
+
+	return nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	return r.cert, nil
+}
+
+// TLSConfig returns the config of a server which serves the current certificate.
+// If there is a client CA, the client certificates are verified when they are
+// presented, but not required, see framework.RequireClientCert.
+func (r *CertReloader) TLSConfig() *tls.Config {
+	c := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+
+	if r.clientCAFile == "" {
+		return c
+	}
+
+	c.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mut.RLock()
+		pool := r.clientCA
+		r.mut.RUnlock()
+
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: r.GetCertificate,
+			ClientAuth:     tls.VerifyClientCertIfGiven,
+			ClientCAs:      pool,
+		}, nil
+	}
+
+	return c
+}
@@ -55,17 +55,18 @@ type singleSecret struct {
 	l    *logrus.Entry
 	path string
 
-	t           utils.Timer
+	w           *utils.FileWatcher
 	skips       int
 	lastModTime time.Time
 	setSecret   func(string, []byte)
 }
 
-// reloadSecret will begin polling the secret file at the path. If the first load
-// fails, Start with return the error and abort. Future load failures will log
-// the failure message but continue attempting to load.
+// load reloads the secret file at the path if it has been modified. It is called
+// when the file changes and on every poll, the file is read regardless of its
+// modification time once in maxSkips polls. Load failures will log the failure
+// message but continue attempting to load.
 func (s *singleSecret) load() {
-	if s.skips < 600 {
+	if s.skips < maxSkips {
 		// Check if the file changed to see if it needs to be re-read.
 		secretStat, err := os.Stat(s.path)
 		if err != nil {
@@ -93,10 +94,18 @@ func (s *singleSecret) load() {
 	s.skips = 0
 }
 
-func (s singleSecret) start() {
-	s.t.Start(s.load, 1*time.Second, 0)
+// pollInterval is the fallback of file watching, combined with
+// maxSkips the secret is reread at least every 10 minutes.
+const (
+	pollInterval = 1 * time.Minute
+	maxSkips     = 10
+)
+
+func (s *singleSecret) start() {
+	s.w = utils.NewFileWatcher(s.path, s.load, utils.DefaultWatchDebounce, pollInterval)
+	s.w.Start()
 }
 
-func (s singleSecret) stop() {
-	s.t.Stop()
+func (s *singleSecret) stop() {
+	s.w.Stop()
 }
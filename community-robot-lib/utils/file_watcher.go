@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultWatchDebounce is how long the file watcher waits for the
+	// notifications of a change to settle before calling back.
+	DefaultWatchDebounce = 500 * time.Millisecond
+
+	// DefaultWatchPollInterval is the interval of polling which is the fallback
+	// of the file watcher in case the notifications are lost or not supported.
+	DefaultWatchPollInterval = 1 * time.Minute
+)
+
+// FileWatcher calls back when a file may have changed. The directory which contains
+// the file is watched instead of the file itself, so that the file being replaced
+// is caught as well as the symlink swaps (..data) done by Kubernetes on the
+// volumes of ConfigMap and Secret. The notifications are debounced, and the
+// file is polled in case they are lost or filesystem notification is not supported.
+//
+// The path can also be a directory, then any change in it counts, or a glob
+// of files in one directory, then the change of any matching file counts.
+//
+// The callback must tolerate being called when the file has not changed.
+type FileWatcher struct {
+	path     string
+	dir      string
+	isDir    bool
+	isGlob   bool
+	onChange func()
+	debounce time.Duration
+	poll     time.Duration
+
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewFileWatcher creates a watcher of path. A debounce or poll which is not
+// positive is replaced by DefaultWatchDebounce or DefaultWatchPollInterval.
+func NewFileWatcher(path string, onChange func(), debounce, poll time.Duration) *FileWatcher {
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+
+	if poll <= 0 {
+		poll = DefaultWatchPollInterval
+	}
+
+	fw := &FileWatcher{
+		path:     path,
+		onChange: onChange,
+		debounce: debounce,
+		poll:     poll,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	switch info, err := os.Stat(path); {
+	case strings.ContainsAny(path, "*?["):
+		fw.isGlob = true
+		fw.dir = filepath.Dir(path)
+	case err == nil && info.IsDir():
+		fw.isDir = true
+		fw.dir = path
+	default:
+		fw.dir = filepath.Dir(path)
+	}
+
+	return fw
+}
+
+// Start begins watching in a goroutine. If the filesystem notification can't
+// be set up, it only logs the error and the watcher relies on polling.
+func (fw *FileWatcher) Start() {
+	l := logrus.WithField("path", fw.path)
+
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = w.Add(fw.dir); err != nil {
+			_ = w.Close()
+		}
+	}
+
+	if err != nil {
+		l.WithError(err).Warn("can't watch the file, polling it instead")
+
+		w = nil
+	}
+
+	go fw.run(w, l)
+}
+
+func (fw *FileWatcher) run(w *fsnotify.Watcher, l *logrus.Entry) {
+	defer close(fw.stopped)
+
+	var events chan fsnotify.Event
+	var errs chan error
+	if w != nil {
+		defer w.Close()
+
+		events, errs = w.Events, w.Errors
+	}
+
+	ticker := time.NewTicker(fw.poll)
+	defer ticker.Stop()
+
+	// the debounce timer is created stopped
+	debounce := time.NewTimer(fw.debounce)
+	if !debounce.Stop() {
+		<-debounce.C
+	}
+	defer debounce.Stop()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+
+				continue
+			}
+
+			if fw.relevant(e) {
+				debounce.Reset(fw.debounce)
+			}
+
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+
+				continue
+			}
+
+			l.WithError(err).Warn("error of watching the file")
+
+		case <-debounce.C:
+			fw.onChange()
+
+		case <-ticker.C:
+			fw.onChange()
+
+		case <-fw.stop:
+			return
+		}
+	}
+}
+
+// relevant tells whether the event may change the content of the watched file.
+func (fw *FileWatcher) relevant(e fsnotify.Event) bool {
+	if e.Op == fsnotify.Chmod {
+		return false
+	}
+
+	if fw.isDir || filepath.Base(e.Name) == "..data" {
+		return true
+	}
+
+	if fw.isGlob {
+		ok, _ := filepath.Match(fw.path, e.Name)
+
+		return ok
+	}
+
+	return filepath.Clean(e.Name) == filepath.Clean(fw.path)
+}
+
+// Stop stops watching and waits for the running callback to return.
+func (fw *FileWatcher) Stop() {
+	fw.once.Do(func() {
+		close(fw.stop)
+	})
+
+	<-fw.stopped
+}
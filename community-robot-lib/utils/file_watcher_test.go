@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitCalled(t *testing.T, called chan struct{}, desc string) {
+	t.Helper()
+
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watcher was not called back after %s", desc)
+	}
+}
+
+func TestFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+
+	// lay the files out like a Kubernetes ConfigMap volume:
+	// config.yaml -> ..data/config.yaml, ..data -> ..v1
+	for _, v := range []string{"..v1", "..v2"} {
+		if err := os.Mkdir(filepath.Join(dir, v), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, v, "config.yaml"), []byte(v), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.Symlink(filepath.Join("..data", "config.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	called := make(chan struct{}, 10)
+	w := NewFileWatcher(path, func() { called <- struct{}{} }, 10*time.Millisecond, time.Hour)
+	w.Start()
+	defer w.Stop()
+
+	// give the watcher time to be set up
+	time.Sleep(100 * time.Millisecond)
+
+	// swap the symlink atomically as kubelet does
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink("..v2", tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	waitCalled(t, called, "swapping ..data")
+
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "..v2" {
+		t.Fatalf("unexpected content after swapping: %q, %v", b, err)
+	}
+
+	// a file which is not the watched one doesn't matter
+	if err := os.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-called:
+		t.Fatal("watcher was called back for an unrelated file")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,56 @@
+package utils
+
+import "sync"
+
+// KeyedExecutor runs the tasks of the same key one after another in the order
+// they are submitted, while the tasks of different keys run in parallel.
+type KeyedExecutor struct {
+	mut    sync.Mutex
+	queues map[string][]func()
+}
+
+func NewKeyedExecutor() *KeyedExecutor {
+	return &KeyedExecutor{queues: make(map[string][]func())}
+}
+
+// Run queues the task behind the running and pending tasks of the same key.
+// It does not block, a goroutine is started per key which has pending tasks.
+func (e *KeyedExecutor) Run(key string, task func()) {
+	e.mut.Lock()
+	q, busy := e.queues[key]
+	e.queues[key] = append(q, task)
+	e.mut.Unlock()
+
+	if !busy {
+		go e.drain(key)
+	}
+}
+
+// Pending returns the number of tasks of key which are running or waiting.
+func (e *KeyedExecutor) Pending(key string) int {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	return len(e.queues[key])
+}
+
+func (e *KeyedExecutor) drain(key string) {
+	for {
+		e.mut.Lock()
+		task := e.queues[key][0]
+		e.mut.Unlock()
+
+		task()
+
+		e.mut.Lock()
+		q := e.queues[key][1:]
+		if len(q) == 0 {
+			delete(e.queues, key)
+			e.mut.Unlock()
+
+			return
+		}
+		e.queues[key] = q
+		e.mut.Unlock()
+	}
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedExecutorKeepsOrderOfSameKey(t *testing.T) {
+	e := NewKeyedExecutor()
+
+	var (
+		mut sync.Mutex
+		got = map[string][]int{}
+		wg  sync.WaitGroup
+	)
+
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"a", "b"} {
+			i, key := i, key
+
+			wg.Add(1)
+			e.Run(key, func() {
+				defer wg.Done()
+
+				// make the earlier tasks slower to expose reordering
+				time.Sleep(time.Duration(50-i) * time.Microsecond)
+
+				mut.Lock()
+				got[key] = append(got[key], i)
+				mut.Unlock()
+			})
+		}
+	}
+
+	wg.Wait()
+
+	for key, v := range got {
+		for i := range v {
+			if v[i] != i {
+				t.Fatalf("tasks of key %s ran out of order: %v", key, v)
+			}
+		}
+	}
+}
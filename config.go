@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"reflect"
 	"sort"
 
+	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/sets"
+
+	"community-robot-lib/framework"
 )
 
 type configuration struct {
@@ -25,6 +29,10 @@ type accessConfig struct {
 
 	// Plugins is a list available plugins.
 	Plugins []pluginConfig `json:"plugins,omitempty"`
+
+	// DynamicPlugins is a list of names of the plugins which register themselves
+	// to the gateway with their endpoint and events, instead of being listed in Plugins.
+	DynamicPlugins []string `json:"dynamic_plugins,omitempty"`
 }
 
 type pluginConfig struct {
@@ -35,17 +43,75 @@ type pluginConfig struct {
 	Endpoint string `json:"endpoint" required:"true"`
 
 	// Events are the events that this plugin can handle and should be forward to it.
-	// If no events are specified, everything is sent.
+	// If no events are specified, nothing is sent except the events of schedules.
 	Events []string `json:"events,omitempty"`
+
+	// Ordered makes the events of the same PR or issue be delivered to the plugin
+	// one after another in the order they come. Events of different PRs or issues
+	// are still delivered in parallel.
+	Ordered bool `json:"ordered,omitempty"`
+
+	// Debounce coalesces the bursts of events, so that the plugin receives only
+	// the latest event of a burst. Nothing is coalesced if it is not specified.
+	Debounce *debounceConfig `json:"debounce,omitempty"`
+
+	// Schedules are the cron schedules which the gateway emits synthetic
+	// events for. The events are delivered to this plugin only.
+	Schedules []scheduleConfig `json:"schedules,omitempty"`
+
+	// EventFormat is how the events are encoded for the plugin: gob, which is
+	// the default and is understood by the plugins of every lib, or proto,
+	// which only the plugins built with a lib of the versioned schema understand.
+	EventFormat string `json:"event_format,omitempty"`
 }
 
+// validate fails on the fields which must be set and on the names which would route
+// ambiguously, the findings of lint are logged as warnings, so that a config which
+// loaded before still loads. The lint command fails on the findings of error severity.
 func (a *accessConfig) validate() error {
+	if err := a.validateRequired(); err != nil {
+		return err
+	}
+
+	for _, f := range a.lint() {
+		logrus.WithField("severity", f.severity).Warning("config lint: ", f.message)
+	}
+
+	return nil
+}
+
+// validateRequired checks the fields which must be set and the
+// references between plugins, see lint for the other checks.
+func (a *accessConfig) validateRequired() error {
 	var botSet = sets.String{}
+	var scheduleSet = sets.String{}
 	for i := range a.Plugins {
 		if err := a.Plugins[i].validate(); err != nil {
 			return err
 		}
+
+		if botSet.Has(a.Plugins[i].Name) {
+			return fmt.Errorf("duplicate plugin: %s", a.Plugins[i].Name)
+		}
 		botSet.Insert(a.Plugins[i].Name)
+
+		for _, s := range a.Plugins[i].Schedules {
+			if scheduleSet.Has(s.Name) {
+				return fmt.Errorf("duplicate schedule: %s", s.Name)
+			}
+			scheduleSet.Insert(s.Name)
+		}
+	}
+
+	for _, name := range a.DynamicPlugins {
+		if name == "" {
+			return fmt.Errorf("missing name of dynamic plugin")
+		}
+
+		if botSet.Has(name) {
+			return fmt.Errorf("plugin %s is both static and dynamic", name)
+		}
+		botSet.Insert(name)
 	}
 
 	var e []string
@@ -65,12 +131,35 @@ func (a *accessConfig) validate() error {
 }
 
 func (c *configuration) GetEndpoints(org, repo, eventType string) (ans []string) {
+	for _, p := range c.GetPlugins(org, repo, eventType) {
+		ans = append(ans, p.Endpoint)
+	}
+
+	return
+}
 
+// GetPlugins returns the plugins which the event of org/repo should be delivered to.
+func (c *configuration) GetPlugins(org, repo, eventType string) (ans []pluginConfig) {
+
+	robotNames := c.pluginNames(org, repo)
+
+	if len(c.ConfigItems.Plugins) != 0 && len(robotNames) != 0 {
+		ans = matchPlugin(&c.ConfigItems.Plugins, eventType, robotNames...)
+	}
+
+	return
+}
+
+func (c *configuration) dynamicPluginSet() sets.String {
+	return sets.NewString(c.ConfigItems.DynamicPlugins...)
+}
+
+// pluginNames returns the names of plugins which org/repo is configured with.
+func (c *configuration) pluginNames(org, repo string) (robotNames []string) {
 	if c.ConfigItems.RepoPlugins == nil {
-		return []string{}
+		return nil
 	}
 
-	var robotNames []string
 	endpoint, ok := c.ConfigItems.RepoPlugins[org]
 	if ok {
 		robotNames = append(robotNames, endpoint...)
@@ -81,21 +170,17 @@ func (c *configuration) GetEndpoints(org, repo, eventType string) (ans []string)
 		robotNames = append(robotNames, endpoint...)
 	}
 
-	if len(c.ConfigItems.Plugins) != 0 && len(robotNames) != 0 {
-		ans = matchEndpoint(&c.ConfigItems.Plugins, eventType, robotNames...)
-	}
-
 	return
 }
 
-func matchEndpoint(m *[]pluginConfig, event string, robotNames ...string) (ans []string) {
+func matchPlugin(m *[]pluginConfig, event string, robotNames ...string) (ans []pluginConfig) {
 	for _, val := range robotNames {
 		for _, value := range *m {
 			if value.Name == val {
 				sort.Strings(value.Events)
 				idx := sort.SearchStrings(value.Events, event)
 				if idx < len(value.Events) && value.Events[idx] == event {
-					ans = append(ans, value.Endpoint)
+					ans = append(ans, value)
 				}
 			}
 		}
@@ -113,6 +198,83 @@ func (p *pluginConfig) validate() error {
 		return fmt.Errorf("missing endpoint")
 	}
 
-	// p.Endpoint unchecked
+	for i := range p.Schedules {
+		if err := p.Schedules[i].validate(); err != nil {
+			return fmt.Errorf("plugin %s: %v", p.Name, err)
+		}
+	}
+
+	if p.Debounce != nil {
+		if err := p.Debounce.validate(); err != nil {
+			return fmt.Errorf("plugin %s: %v", p.Name, err)
+		}
+	}
+
+	if p.EventFormat != "" && !framework.ValidEventFormat(p.EventFormat) {
+		return fmt.Errorf("plugin %s: unknown event_format %s", p.Name, p.EventFormat)
+	}
+
 	return nil
 }
+
+// routeChanges describes how the routing differs between two configs.
+type routeChanges struct {
+	addedPlugins   []string
+	removedPlugins []string
+	changedPlugins []string
+	reroutedRepos  []string
+}
+
+func diffRoutes(old, c *configuration) (r routeChanges) {
+	index := func(cfg *configuration) map[string]*pluginConfig {
+		m := make(map[string]*pluginConfig)
+		if cfg == nil {
+			return m
+		}
+
+		for i := range cfg.ConfigItems.Plugins {
+			m[cfg.ConfigItems.Plugins[i].Name] = &cfg.ConfigItems.Plugins[i]
+		}
+		for _, name := range cfg.ConfigItems.DynamicPlugins {
+			m[name] = nil
+		}
+
+		return m
+	}
+
+	op, np := index(old), index(c)
+	for name, p := range np {
+		v, ok := op[name]
+		switch {
+		case !ok:
+			r.addedPlugins = append(r.addedPlugins, name)
+		case !reflect.DeepEqual(v, p):
+			r.changedPlugins = append(r.changedPlugins, name)
+		}
+	}
+	for name := range op {
+		if _, ok := np[name]; !ok {
+			r.removedPlugins = append(r.removedPlugins, name)
+		}
+	}
+
+	var or, nr map[string][]string
+	if old != nil {
+		or = old.ConfigItems.RepoPlugins
+	}
+	if c != nil {
+		nr = c.ConfigItems.RepoPlugins
+	}
+	for k := range sets.StringKeySet(or).Union(sets.StringKeySet(nr)) {
+		if !sets.NewString(or[k]...).Equal(sets.NewString(nr[k]...)) {
+			r.reroutedRepos = append(r.reroutedRepos, k)
+		}
+	}
+
+	sort.Strings(r.addedPlugins)
+	sort.Strings(r.removedPlugins)
+	sort.Strings(r.changedPlugins)
+	sort.Strings(r.reroutedRepos)
+
+	return
+}
@@ -0,0 +1,37 @@
+package main
+
+import "k8s.io/apimachinery/pkg/util/sets"
+
+// platformEvents lists the event names which each platform sends by webhook.
+// It is used to catch typos of events in config. "Tag Push Hook" and "tag_push"
+// are delivered as framework.PushEvent, see RegisterPushCodeBranchTagHandler.
+var platformEvents = map[string][]string{
+	"gitee": {
+		"Push Hook", "Tag Push Hook", "Issue Hook", "Merge Request Hook", "Note Hook",
+	},
+	"gitlab": {
+		"Push Hook", "Tag Push Hook", "Issue Hook", "Merge Request Hook", "Note Hook",
+		"Pipeline Hook", "Job Hook", "Release Hook", "Member Hook",
+	},
+	"github": {
+		"push", "create", "delete", "issues", "issue_comment", "pull_request",
+		"pull_request_review", "pull_request_review_comment", "release", "status",
+		"check_run", "check_suite", "workflow_run", "member", "membership", "repository",
+	},
+	"atomgit": {
+		"push", "tag_push", "issues", "issue_comment", "pull_requests",
+		"pull_request_review_comment", "note",
+	},
+	schedulePlatform: {
+		scheduleEventName,
+	},
+}
+
+var knownEvents = func() sets.String {
+	s := sets.NewString()
+	for _, v := range platformEvents {
+		s.Insert(v...)
+	}
+
+	return s
+}()
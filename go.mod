@@ -5,6 +5,7 @@ go 1.20
 require (
 	//community-robot-lib v0.0.0-00010101000000-000000000000
 	//git-platform-sdk v0.0.0-00010101000000-000000000000
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.9.3
 	k8s.io/apimachinery v0.29.1
 )
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	limitModeShed  = "shed"
+	limitModeDefer = "defer"
+
+	// maxLimiterBuckets bounds the number of keys tracked by a keyedLimiter
+	// before the idle ones are pruned.
+	maxLimiterBuckets = 10000
+)
+
+type limitOptions struct {
+	MaxBodySize       int64
+	SourceRate        float64
+	SourceBurst       int
+	OrgRate           float64
+	OrgBurst          int
+	RepoRate          float64
+	RepoBurst         int
+	Mode              string
+	DeferredQueueSize int
+}
+
+func (o *limitOptions) AddFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&o.MaxBodySize, "max-body-size", 10<<20, "Maximum size in bytes of a webhook payload, larger ones are rejected with 413.")
+	fs.Float64Var(&o.SourceRate, "source-rate-limit", 0, "Webhooks accepted per second from one source ip, 0 means no limit.")
+	fs.IntVar(&o.SourceBurst, "source-rate-burst", 50, "Burst size of the source ip rate limit.")
+	fs.Float64Var(&o.OrgRate, "org-rate-limit", 0, "Events accepted per second for one org, 0 means no limit.")
+	fs.IntVar(&o.OrgBurst, "org-rate-burst", 100, "Burst size of the org rate limit.")
+	fs.Float64Var(&o.RepoRate, "repo-rate-limit", 0, "Events accepted per second for one org/repo, 0 means no limit.")
+	fs.IntVar(&o.RepoBurst, "repo-rate-burst", 20, "Burst size of the org/repo rate limit.")
+	fs.StringVar(&o.Mode, "rate-limit-mode", limitModeShed, "What to do with events over the org or org/repo limit: shed (reply 429) or defer (park and deliver later).")
+	fs.IntVar(&o.DeferredQueueSize, "deferred-queue-size", 1000, "Maximum number of events parked when rate-limit-mode is defer.")
+}
+
+func (o *limitOptions) Validate() error {
+	if o.MaxBodySize <= 0 {
+		return fmt.Errorf("max-body-size must be positive")
+	}
+
+	if o.SourceRate < 0 || o.OrgRate < 0 || o.RepoRate < 0 {
+		return fmt.Errorf("rate limits can't be negative")
+	}
+
+	if o.Mode != limitModeShed && o.Mode != limitModeDefer {
+		return fmt.Errorf("unknown rate-limit-mode: %s", o.Mode)
+	}
+
+	if o.Mode == limitModeDefer && o.DeferredQueueSize <= 0 {
+		return fmt.Errorf("deferred-queue-size must be positive")
+	}
+
+	return nil
+}
+
+// tokenBucket holds the state of a single key, it is refilled lazily.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// keyedLimiter is a token bucket rate limiter per key.
+// A rate which is not positive disables the limiter.
+type keyedLimiter struct {
+	mut     sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newKeyedLimiter(rate float64, burst int) *keyedLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &keyedLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (l *keyedLimiter) enabled() bool {
+	return l != nil && l.rate > 0
+}
+
+// bucket returns the refilled bucket of key. It must be called with mut held.
+func (l *keyedLimiter) bucket(key string, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxLimiterBuckets {
+			l.prune(now)
+		}
+
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+
+		return b
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.last = now
+	}
+
+	return b
+}
+
+// prune drops the buckets that would be full by now, they are
+// indistinguishable from the ones created on demand.
+func (l *keyedLimiter) prune(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// allow takes a token of key if there is one.
+func (l *keyedLimiter) allow(key string) bool {
+	if !l.enabled() {
+		return true
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	b := l.bucket(key, time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// reserve takes a token of key even if the bucket is empty and returns
+// how long the caller has to wait before the token is really available.
+func (l *keyedLimiter) reserve(key string) time.Duration {
+	if !l.enabled() {
+		return 0
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	b := l.bucket(key, time.Now())
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / l.rate * float64(time.Second))
+}
+
+// refund gives back the token of key which is taken by allow or reserve
+// but not used.
+func (l *keyedLimiter) refund(key string) {
+	if !l.enabled() {
+		return
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	b := l.bucket(key, time.Now())
+	if b.tokens++; b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+}
+
+type parkedEvent struct {
+	timer   *time.Timer
+	deliver func()
+}
+
+// rateLimiter protects the gateway from oversized payloads and webhook storms.
+// The source ip limit and the payload size are always enforced when the request
+// comes in. The org and org/repo limits are enforced at the same place in shed
+// mode, while in defer mode the events over the limit are parked and delivered
+// once the limit allows it.
+type rateLimiter struct {
+	maxBodySize int64
+	mode        string
+
+	source *keyedLimiter
+	org    *keyedLimiter
+	repo   *keyedLimiter
+
+	deferredMax int
+
+	mut    sync.Mutex
+	seq    uint64
+	parked map[uint64]parkedEvent
+	// closed is set on shutdown, the events are not parked any more
+	closed bool
+}
+
+func newRateLimiter(opt *limitOptions) *rateLimiter {
+	return &rateLimiter{
+		maxBodySize: opt.MaxBodySize,
+		mode:        opt.Mode,
+		source:      newKeyedLimiter(opt.SourceRate, opt.SourceBurst),
+		org:         newKeyedLimiter(opt.OrgRate, opt.OrgBurst),
+		repo:        newKeyedLimiter(opt.RepoRate, opt.RepoBurst),
+		deferredMax: opt.DeferredQueueSize,
+		parked:      make(map[uint64]parkedEvent),
+	}
+}
+
+func (rl *rateLimiter) allowSource(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return rl.source.allow(host)
+}
+
+// allowRepo is used in shed mode, the event is accepted only if
+// neither the org nor the org/repo is over its limit. The token of
+// org is given back if the org/repo rejects the event.
+func (rl *rateLimiter) allowRepo(org, repo string) bool {
+	if rl.mode != limitModeShed {
+		return true
+	}
+
+	if !rl.org.allow(org) {
+		return false
+	}
+
+	if !rl.repo.allow(org + "/" + repo) {
+		rl.org.refund(org)
+
+		return false
+	}
+
+	return true
+}
+
+// delay is used in defer mode, it returns how long the delivery of
+// an event of org/repo has to be postponed.
+func (rl *rateLimiter) delay(org, repo string) time.Duration {
+	if rl.mode != limitModeDefer {
+		return 0
+	}
+
+	d := rl.org.reserve(org)
+	if v := rl.repo.reserve(org + "/" + repo); v > d {
+		d = v
+	}
+
+	return d
+}
+
+// park runs f after d, which is returned by delay for org/repo. If the deferred
+// queue is full, it gives back the tokens reserved by delay and returns false,
+// and f is never run. Once the limiter is closed, f is run at once.
+func (rl *rateLimiter) park(org, repo string, d time.Duration, f func()) bool {
+	rl.mut.Lock()
+
+	if rl.closed {
+		rl.mut.Unlock()
+		f()
+
+		return true
+	}
+
+	if len(rl.parked) >= rl.deferredMax {
+		rl.mut.Unlock()
+		rl.org.refund(org)
+		rl.repo.refund(org + "/" + repo)
+
+		return false
+	}
+
+	rl.seq++
+	id := rl.seq
+	rl.parked[id] = parkedEvent{
+		deliver: f,
+		timer: time.AfterFunc(d, func() {
+			if rl.unpark(id) {
+				f()
+			}
+		}),
+	}
+
+	rl.mut.Unlock()
+
+	return true
+}
+
+// unpark removes the parked event, it returns false if the event is flushed already.
+func (rl *rateLimiter) unpark(id uint64) bool {
+	rl.mut.Lock()
+	defer rl.mut.Unlock()
+
+	_, ok := rl.parked[id]
+	delete(rl.parked, id)
+
+	return ok
+}
+
+// flush closes the limiter on shutdown and runs the parked events at once,
+// so that they are delivered, though early, rather than lost.
+func (rl *rateLimiter) flush() int {
+	rl.mut.Lock()
+	rl.closed = true
+	parked := rl.parked
+	rl.parked = make(map[uint64]parkedEvent)
+	rl.mut.Unlock()
+
+	for _, p := range parked {
+		p.timer.Stop()
+		p.deliver()
+	}
+
+	return len(parked)
+}
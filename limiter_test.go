@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(mode string, queueSize int) *rateLimiter {
+	return newRateLimiter(&limitOptions{
+		MaxBodySize:       1 << 20,
+		OrgRate:           1,
+		OrgBurst:          2,
+		RepoRate:          1,
+		RepoBurst:         1,
+		Mode:              mode,
+		DeferredQueueSize: queueSize,
+	})
+}
+
+func tokens(l *keyedLimiter, key string) float64 {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.bucket(key, time.Now()).tokens
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	l := newKeyedLimiter(1, 2)
+
+	if !l.allow("a") || !l.allow("a") {
+		t.Fatal("expected the burst to be allowed")
+	}
+	if l.allow("a") {
+		t.Error("expected the third event to be rejected")
+	}
+	if !l.allow("b") {
+		t.Error("expected the keys to be limited separately")
+	}
+
+	if d := l.reserve("a"); d <= 0 || d > time.Second {
+		t.Errorf("expected a delay up to a second, got %s", d)
+	}
+
+	l.refund("a")
+	if d := l.reserve("a"); d <= 0 || d > time.Second {
+		t.Errorf("expected the refunded token to be reserved again, got %s", d)
+	}
+
+	if !newKeyedLimiter(0, 1).allow("a") {
+		t.Error("expected no limit when the rate is not positive")
+	}
+}
+
+func TestAllowRepoKeepsOrgToken(t *testing.T) {
+	rl := newTestLimiter(limitModeShed, 0)
+
+	if !rl.allowRepo("org", "repo") {
+		t.Fatal("expected the first event to be allowed")
+	}
+
+	// the repo is over its limit, the org must not pay for it
+	before := tokens(rl.org, "org")
+	if rl.allowRepo("org", "repo") {
+		t.Fatal("expected the repo to be over its limit")
+	}
+	if after := tokens(rl.org, "org"); after < before {
+		t.Errorf("expected the org token to be given back, got %v after %v", after, before)
+	}
+
+	if !rl.allowRepo("org", "other") {
+		t.Error("expected another repo of the org to be allowed")
+	}
+}
+
+func TestParkRefundsWhenFull(t *testing.T) {
+	rl := newTestLimiter(limitModeDefer, 1)
+
+	var ran int32
+	f := func() { atomic.AddInt32(&ran, 1) }
+
+	for i := 0; i < 3; i++ {
+		rl.delay("org", "repo")
+	}
+	d := rl.delay("org", "repo")
+	if !rl.park("org", "repo", d, f) {
+		t.Fatal("expected the event to be parked")
+	}
+
+	before := tokens(rl.repo, "org/repo")
+	if rl.park("org", "repo", rl.delay("org", "repo"), f) {
+		t.Fatal("expected the queue to be full")
+	}
+	if after := tokens(rl.repo, "org/repo"); after < before-0.01 {
+		t.Errorf("expected the reservation to be given back, got %v after %v", after, before)
+	}
+
+	if n := rl.flush(); n != 1 || atomic.LoadInt32(&ran) != 1 {
+		t.Fatalf("expected the parked event to be run on flush, got %d, %d", n, ran)
+	}
+
+	// once closed, the events are run at once
+	if !rl.park("org", "repo", time.Hour, f) || atomic.LoadInt32(&ran) != 2 {
+		t.Errorf("expected the event to be run at once after flush, got %d", ran)
+	}
+}
+
+func TestParkedEventRunsOnce(t *testing.T) {
+	rl := newTestLimiter(limitModeDefer, 10)
+
+	var ran int32
+	rl.park("org", "repo", time.Millisecond, func() { atomic.AddInt32(&ran, 1) })
+
+	time.Sleep(20 * time.Millisecond)
+	rl.flush()
+
+	if v := atomic.LoadInt32(&ran); v != 1 {
+		t.Errorf("expected the event to be run once, got %d", v)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+)
+
+type lintFinding struct {
+	severity string
+	message  string
+}
+
+func (f lintFinding) String() string {
+	return f.severity + ": " + f.message
+}
+
+// lint checks the semantics of the config beyond the required fields. The findings
+// are only logged when the config is loaded, the lint command fails on the ones of
+// error severity.
+func (a *accessConfig) lint() []lintFinding {
+	var ans []lintFinding
+	add := func(severity, format string, args ...interface{}) {
+		ans = append(ans, lintFinding{severity: severity, message: fmt.Sprintf(format, args...)})
+	}
+
+	names := sets.NewString()
+	for i := range a.Plugins {
+		p := &a.Plugins[i]
+
+		names.Insert(p.Name)
+
+		if p.Endpoint != "" {
+			if err := validateEndpoint(p.Endpoint); err != nil {
+				add(lintError, "plugin %s: %v", p.Name, err)
+			}
+		}
+
+		for _, e := range p.Events {
+			if !knownEvents.Has(e) {
+				add(lintWarning, "plugin %s: event %q is unknown to any platform", p.Name, e)
+			}
+		}
+	}
+
+	routed := sets.NewString()
+	for _, scope := range sortedScopes(a.RepoPlugins) {
+		for _, name := range a.RepoPlugins[scope] {
+			routed.Insert(name)
+
+			for i := range a.Plugins {
+				p := &a.Plugins[i]
+				if p.Name == name && len(p.Events) == 0 && len(p.Schedules) == 0 {
+					add(lintWarning, "%s is routed to plugin %s which subscribes to no events", scope, name)
+				}
+			}
+		}
+	}
+
+	for _, name := range append(names.List(), a.DynamicPlugins...) {
+		if !routed.Has(name) {
+			add(lintWarning, "plugin %s is referenced by no repo", name)
+		}
+	}
+
+	return ans
+}
+
+func sortedScopes(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// runLint checks the config file given by args and writes the findings to out.
+// It returns the exit code, which is not zero if there is any error.
+func runLint(args []string, out io.Writer) int {
+	var o configFlags
+
+	fs := newFlagSet("lint", out)
+	o.AddFlags(fs)
+	if err := parseFlags(fs, args, out); err != nil {
+		return 2
+	}
+
+	c, err := o.load()
+	if err != nil {
+		fmt.Fprintf(out, "%s: %v\n", lintError, err)
+
+		return 1
+	}
+
+	failed := false
+
+	findings := c.ConfigItems.lint()
+	if err := c.ConfigItems.validateRequired(); err != nil {
+		fmt.Fprintf(out, "%s: %v\n", lintError, err)
+		failed = true
+	}
+
+	for _, f := range findings {
+		fmt.Fprintln(out, f.String())
+
+		if f.severity == lintError {
+			failed = true
+		}
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
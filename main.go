@@ -2,23 +2,23 @@ package main
 
 import (
 	"flag"
-	_ "net/http"
-	_ "strconv"
-	"time"
+	"fmt"
+	"io"
+	"os"
 
-	_ "community-robot-lib/config"
 	"community-robot-lib/framework"
-	_ "community-robot-lib/interrupts"
 	"community-robot-lib/logrusutil"
 	liboptions "community-robot-lib/options"
 	"community-robot-lib/secret"
-	_ "community-robot-lib/utils"
 	"github.com/sirupsen/logrus"
 )
 
 type options struct {
-	service liboptions.ServiceOptions
-	client  liboptions.ClientOptions
+	service  liboptions.ServiceOptions
+	client   liboptions.ClientOptions
+	limit    limitOptions
+	registry registryOptions
+	schedule scheduleOptions
 }
 
 func (o *options) Validate() error {
@@ -26,49 +26,88 @@ func (o *options) Validate() error {
 		return err
 	}
 
+	if err := o.limit.Validate(); err != nil {
+		return err
+	}
+
+	if err := o.registry.Validate(); err != nil {
+		return err
+	}
+
+	if o.service.AdminTokenPath != "" && o.service.AdminTokenPath == o.client.TokenPath {
+		return fmt.Errorf("admin-token-path must differ from the path of the webhook secret")
+	}
+
 	return o.client.Validate()
 }
 
-func gatherOptions(fs *flag.FlagSet, args ...string) options {
+func gatherOptions(fs *flag.FlagSet, args ...string) (options, error) {
 	var opt options
 
 	opt.client.AddFlags(fs)
 	opt.service.AddFlags(fs)
+	opt.limit.AddFlags(fs)
+	opt.registry.AddFlags(fs)
+	opt.schedule.AddFlags(fs)
+	fs.StringVar(&opt.client.HandlerPath, "handler-path", "/atomgit-hook", "Path of the url which receives the webhooks.")
+
+	// the defaults of the gateway which differ from the ones of the library
+	setFlagDefault(fs, "port", "8822")
+	setFlagDefault(fs, "admin-port", "8823")
+	setFlagDefault(fs, "grace-period", "300s")
+	setFlagDefault(fs, "ordered-dispatch", "true")
+	setFlagDefault(fs, "workers", "64")
 
-	_ = fs.Parse(args)
+	err := parseFlags(fs, args, fs.Output())
+
+	return opt, err
+}
 
-	return opt
+// setFlagDefault changes the default of the flag, unlike fs.Set
+// the flag still counts as unset, so that the env var can set it.
+func setFlagDefault(fs *flag.FlagSet, name, value string) {
+	f := fs.Lookup(name)
+	_ = f.Value.Set(value)
+	f.DefValue = value
 }
 
 func main() {
-	logrusutil.ComponentInit(botName)
+	os.Exit(runCommand(os.Args[1:], os.Stdout))
+}
 
-	//o := gatherOptions(flag.NewFlagSet(os.Args[0], flag.ExitOnError), os.Args[1:]...)
-	opt := options{
-		service: liboptions.ServiceOptions{
-			Port:        8822,
-			ConfigFile:  "D:\\Project\\github\\ibfru\\robot-gateway\\local\\config.yaml",
-			GracePeriod: 300 * time.Second,
-		},
-		client: liboptions.ClientOptions{
-			TokenPath:   "D:\\Project\\github\\ibfru\\robot-gateway\\local\\secret",
-			HandlerPath: "/atomgit-hook",
-		},
+func runServe(args []string, out io.Writer) int {
+	fs := newFlagSet("serve", out)
+	opt, err := gatherOptions(fs, args...)
+	if err != nil {
+		return 2
 	}
 
+	logrusutil.ComponentInit(botName)
+
 	if err := opt.Validate(); err != nil {
 		logrus.WithError(err).Fatal("Invalid options")
 	}
 
+	secrets := []string{opt.client.TokenPath}
+	if opt.service.AdminTokenPath != "" {
+		secrets = append(secrets, opt.service.AdminTokenPath)
+	}
+
 	secretAgent := new(secret.Agent)
-	if err := secretAgent.Start([]string{opt.client.TokenPath}); err != nil {
+	if err := secretAgent.Start(secrets); err != nil {
 		logrus.WithError(err).Fatal("Error starting secret agent.")
 	}
 	defer secretAgent.Stop()
 
-	// to replace
-
-	p := newRobot()
+	p := newRobot(&opt.limit, &opt.registry, &opt.schedule)
 	opt.client.TokenGenerator = secretAgent.GetTokenGenerator(opt.client.TokenPath)
-	framework.Run(p, opt.service, opt.client)
+	if opt.service.AdminTokenPath != "" {
+		opt.service.AdminTokenGenerator = secretAgent.GetTokenGenerator(opt.service.AdminTokenPath)
+	}
+
+	s := framework.NewServer(p, opt.service, opt.client)
+	s.Handle(registryPath, framework.RequireClientCert(p.registryHandler(opt.registry.pluginSecret), opt.service))
+	s.Run()
+
+	return 0
 }
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"community-robot-lib/secret"
+)
+
+const (
+	registryPath        = "/plugins/"
+	registerAction      = "register"
+	heartbeatAction     = "heartbeat"
+	deregisterAction    = "deregister"
+	registryTokenPrefix = "Bearer "
+)
+
+type registryOptions struct {
+	Lease     time.Duration
+	SecretDir string
+}
+
+func (o *registryOptions) AddFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&o.Lease, "plugin-lease", 90*time.Second, "How long a self-registered plugin stays routable without a heartbeat.")
+	fs.StringVar(&o.SecretDir, "plugin-secret-dir", "", "Path to the directory which holds the registration secret of each dynamic plugin in a file named after it. The plugins can't register if it is empty.")
+}
+
+// pluginSecret reads the registration secret of the plugin. It is read on each
+// request, so that a secret can be rotated without restarting the gateway.
+func (o *registryOptions) pluginSecret(name string) []byte {
+	if o.SecretDir == "" || name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil
+	}
+
+	v, err := secret.LoadSingleSecret(filepath.Join(o.SecretDir, name))
+	if err != nil {
+		logrus.WithError(err).WithField("plugin", name).Warning("can't load the registration secret")
+
+		return nil
+	}
+
+	return v
+}
+
+func (o *registryOptions) Validate() error {
+	if o.Lease <= 0 {
+		return fmt.Errorf("plugin-lease must be positive")
+	}
+
+	return nil
+}
+
+// registration is the body of the register, heartbeat and deregister requests.
+// Only the name is needed by heartbeat and deregister.
+type registration struct {
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint,omitempty"`
+	Events   []string `json:"events,omitempty"`
+
+	EventFormat string `json:"event_format,omitempty"`
+}
+
+type pluginLease struct {
+	plugin   pluginConfig
+	expireAt time.Time
+}
+
+// registry holds the plugins which registered themselves. The static config
+// stays authoritative: a registered plugin only receives the events of the
+// repos which repo_plugins routes to it, and only the names listed in
+// dynamic_plugins can register.
+type registry struct {
+	mut    sync.RWMutex
+	lease  time.Duration
+	leases map[string]*pluginLease
+}
+
+func newRegistry(lease time.Duration) *registry {
+	return &registry{
+		lease:  lease,
+		leases: make(map[string]*pluginLease),
+	}
+}
+
+func (r *registry) register(p pluginConfig) time.Time {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	v := &pluginLease{plugin: p, expireAt: time.Now().Add(r.lease)}
+	r.leases[p.Name] = v
+
+	return v.expireAt
+}
+
+// heartbeat renews the lease of name. It returns false if the plugin
+// is unknown or its lease has expired, then the plugin must register again.
+func (r *registry) heartbeat(name string) (time.Time, bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	v, ok := r.leases[name]
+	if !ok || time.Now().After(v.expireAt) {
+		delete(r.leases, name)
+
+		return time.Time{}, false
+	}
+
+	v.expireAt = time.Now().Add(r.lease)
+
+	return v.expireAt, true
+}
+
+func (r *registry) deregister(name string) {
+	r.mut.Lock()
+	delete(r.leases, name)
+	r.mut.Unlock()
+}
+
+// live returns the plugins whose lease has not expired, the expired ones are removed.
+func (r *registry) live() []pluginConfig {
+	now := time.Now()
+
+	r.mut.RLock()
+	ans := make([]pluginConfig, 0, len(r.leases))
+	expired := false
+	for _, v := range r.leases {
+		if now.After(v.expireAt) {
+			expired = true
+		} else {
+			ans = append(ans, v.plugin)
+		}
+	}
+	r.mut.RUnlock()
+
+	if expired {
+		r.mut.Lock()
+		for k, v := range r.leases {
+			if now.After(v.expireAt) {
+				delete(r.leases, k)
+				logrus.WithField("plugin", k).Info("the lease of plugin expired")
+			}
+		}
+		r.mut.Unlock()
+	}
+
+	return ans
+}
+
+// resolve returns a config whose plugins include the live registered ones
+// that c allows to register.
+func (r *registry) resolve(c *configuration) *configuration {
+	if len(c.ConfigItems.DynamicPlugins) == 0 {
+		return c
+	}
+
+	allowed := c.dynamicPluginSet()
+
+	var extra []pluginConfig
+	for _, p := range r.live() {
+		if allowed.Has(p.Name) {
+			extra = append(extra, p)
+		}
+	}
+
+	if len(extra) == 0 {
+		return c
+	}
+
+	v := *c
+	v.ConfigItems.Plugins = append(append([]pluginConfig{}, c.ConfigItems.Plugins...), extra...)
+
+	return &v
+}
+
+// registryHandler serves the registration api of plugins. Every request must
+// carry the registration secret of the plugin it names in the Authorization
+// header as a bearer token, so that a plugin can't act as another one.
+type registryHandler struct {
+	r         *registry
+	secret    func(name string) []byte
+	getConfig func() *configuration
+}
+
+func (h *registryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body registration
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20)).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	c := h.getConfig()
+	if c == nil {
+		http.Error(w, "gateway is not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	// authenticated first, so that the names which can register are
+	// not told to the callers without a secret
+	if !h.authorized(req, body.Name) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !c.dynamicPluginSet().Has(body.Name) {
+		http.Error(w, "the plugin is not allowed to register", http.StatusForbidden)
+		return
+	}
+
+	lgr := logrus.WithField("plugin", body.Name)
+
+	switch strings.TrimPrefix(req.URL.Path, registryPath) {
+	case registerAction:
+		p := pluginConfig{
+			Name:        body.Name,
+			Endpoint:    body.Endpoint,
+			Events:      body.Events,
+			EventFormat: body.EventFormat,
+		}
+		if err := p.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validateEndpoint(p.Endpoint); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		expireAt := h.r.register(p)
+		lgr.WithField("endpoint", p.Endpoint).Info("plugin registered")
+		writeLease(w, expireAt)
+
+	case heartbeatAction:
+		expireAt, ok := h.r.heartbeat(body.Name)
+		if !ok {
+			http.Error(w, "the plugin is not registered", http.StatusNotFound)
+			return
+		}
+		writeLease(w, expireAt)
+
+	case deregisterAction:
+		h.r.deregister(body.Name)
+		lgr.Info("plugin deregistered")
+
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (h *registryHandler) authorized(req *http.Request, name string) bool {
+	v := req.Header.Get("Authorization")
+	if !strings.HasPrefix(v, registryTokenPrefix) {
+		return false
+	}
+
+	token := h.secret(name)
+	if len(token) == 0 {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(v, registryTokenPrefix)), token) == 1
+}
+
+func writeLease(w http.ResponseWriter, expireAt time.Time) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"expire_at": expireAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// validateEndpoint checks that endpoint is an absolute http(s) url.
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %s: %v", endpoint, err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint %s is not an absolute http(s) url", endpoint)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"community-robot-lib/config"
+)
+
+// newTestRegistry returns the registry handler of a gateway whose dynamic plugins are
+// a, b and c, the secrets are kept for a, b and d, which is not a dynamic plugin.
+func newTestRegistry(t *testing.T, lease time.Duration) (*robot, func(action, token, body string) *httptest.ResponseRecorder) {
+	dir := t.TempDir()
+	for name, v := range map[string]string{"a": "secret-a\n", "b": "secret-b", "d": "secret-d"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(v), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opt := registryOptions{Lease: lease, SecretDir: dir}
+	bot := newRobot(&limitOptions{}, &opt, &scheduleOptions{})
+	h := bot.registryHandler(opt.pluginSecret)
+
+	c := &configuration{ConfigItems: accessConfig{DynamicPlugins: []string{"a", "b", "c"}}}
+	bot.currentConfig.Store(func() config.Config { return c })
+
+	return bot, func(action, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, registryPath+action, strings.NewReader(body))
+		req.Header.Set("Authorization", registryTokenPrefix+token)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		return w
+	}
+}
+
+func TestRegistryHandlerNotReady(t *testing.T) {
+	bot := newRobot(&limitOptions{}, &registryOptions{Lease: time.Minute}, &scheduleOptions{})
+	h := bot.registryHandler(func(string) []byte { return []byte("secret") })
+
+	req := httptest.NewRequest(http.MethodPost, registryPath+heartbeatAction, strings.NewReader(`{"name":"a"}`))
+	req.Header.Set("Authorization", registryTokenPrefix+"secret")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d before the config is ready, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestRegistryHandlerSecret(t *testing.T) {
+	_, do := newTestRegistry(t, time.Minute)
+
+	cases := []struct {
+		name  string
+		token string
+		body  string
+		code  int
+	}{
+		{"own secret", "secret-a", `{"name":"a"}`, http.StatusNotFound},
+		{"secret of another plugin", "secret-b", `{"name":"a"}`, http.StatusUnauthorized},
+		{"no secret file", "secret-a", `{"name":"c"}`, http.StatusUnauthorized},
+		// the names which can register are not told without a secret
+		{"unknown plugin", "secret-a", `{"name":"e"}`, http.StatusUnauthorized},
+		{"not dynamic", "secret-d", `{"name":"d"}`, http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		if w := do(heartbeatAction, tc.token, tc.body); w.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.code, w.Code)
+		}
+	}
+}
+
+func TestRegistryHandlerFlow(t *testing.T) {
+	bot, do := newTestRegistry(t, time.Minute)
+
+	if w := do(registerAction, "secret-a", `{"name":"a","endpoint":"localhost/hook"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected the invalid endpoint to be rejected, got %d", w.Code)
+	}
+
+	w := do(registerAction, "secret-a", `{"name":"a","endpoint":"http://a:8080/hook","events":["Merge Request Hook"]}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "expire_at") {
+		t.Fatalf("expected the plugin to be registered, got %d %s", w.Code, w.Body)
+	}
+
+	live := bot.registry.live()
+	if len(live) != 1 || live[0].Name != "a" || live[0].Endpoint != "http://a:8080/hook" {
+		t.Fatalf("unexpected live plugins: %+v", live)
+	}
+
+	if w := do(heartbeatAction, "secret-a", `{"name":"a"}`); w.Code != http.StatusOK {
+		t.Errorf("expected the lease to be renewed, got %d", w.Code)
+	}
+
+	if w := do(deregisterAction, "secret-a", `{"name":"a"}`); w.Code != http.StatusOK {
+		t.Errorf("expected the plugin to be deregistered, got %d", w.Code)
+	}
+
+	if w := do(heartbeatAction, "secret-a", `{"name":"a"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected the deregistered plugin to register again, got %d", w.Code)
+	}
+	if n := len(bot.registry.live()); n != 0 {
+		t.Errorf("expected no live plugin, got %d", n)
+	}
+
+	if w := do("unknown", "secret-a", `{"name":"a"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected unknown action to be not found, got %d", w.Code)
+	}
+}
+
+func TestRegistryLeaseExpiry(t *testing.T) {
+	r := newRegistry(10 * time.Millisecond)
+	r.register(pluginConfig{Name: "a", Endpoint: "http://a"})
+	r.register(pluginConfig{Name: "b", Endpoint: "http://b"})
+
+	if n := len(r.live()); n != 2 {
+		t.Fatalf("expected 2 live plugins, got %d", n)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	r.register(pluginConfig{Name: "b", Endpoint: "http://b"})
+
+	if live := r.live(); len(live) != 1 || live[0].Name != "b" {
+		t.Errorf("expected only b to be live, got %+v", live)
+	}
+	if _, ok := r.leases["a"]; ok {
+		t.Error("expected the expired lease to be removed")
+	}
+	if _, ok := r.heartbeat("a"); ok {
+		t.Error("expected the heartbeat of expired plugin to fail")
+	}
+}
+
+func TestRegistryResolve(t *testing.T) {
+	r := newRegistry(time.Minute)
+	r.register(pluginConfig{Name: "a", Endpoint: "http://a"})
+	r.register(pluginConfig{Name: "x", Endpoint: "http://x"})
+
+	static := make([]pluginConfig, 1, 4)
+	static[0] = pluginConfig{Name: "s", Endpoint: "http://s"}
+
+	c := &configuration{ConfigItems: accessConfig{Plugins: static, DynamicPlugins: []string{"a", "b"}}}
+
+	got := r.resolve(c)
+	if got == c {
+		t.Fatal("expected a new config with the registered plugins")
+	}
+
+	names := make([]string, 0, len(got.ConfigItems.Plugins))
+	for _, p := range got.ConfigItems.Plugins {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, ",") != "s,a" {
+		t.Errorf("expected the static plugin and a only, got %v", names)
+	}
+
+	// the config is shared by the other events, so it is kept as it is
+	if len(c.ConfigItems.Plugins) != 1 || static[:2][1].Name != "" {
+		t.Errorf("expected the shared config not to be changed, got %+v", c.ConfigItems.Plugins)
+	}
+
+	if v := r.resolve(&configuration{}); len(v.ConfigItems.Plugins) != 0 {
+		t.Errorf("expected no plugin without dynamic plugins, got %+v", v.ConfigItems.Plugins)
+	}
+}
+
+func TestPluginSecretName(t *testing.T) {
+	opt := registryOptions{SecretDir: t.TempDir()}
+
+	for _, name := range []string{"", ".", "..", "../a", "a/b"} {
+		if v := opt.pluginSecret(name); v != nil {
+			t.Errorf("expected no secret of %q", name)
+		}
+	}
+}
@@ -11,20 +11,42 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"sync/atomic"
 )
 
 const botName = "robot-atomgit-access"
 
-func newRobot() *robot {
-	return &robot{hc: utils.NewHttpClient(3)}
+func newRobot(limit *limitOptions, reg *registryOptions, sched *scheduleOptions) *robot {
+	return &robot{
+		hc:            utils.NewHttpClient(3),
+		limiter:       newRateLimiter(limit),
+		ordered:       utils.NewKeyedExecutor(),
+		debouncer:     newDebouncer(),
+		registry:      newRegistry(reg.Lease),
+		scheduleState: sched.StateFile,
+	}
 }
 
 type robot struct {
 	// ec is an http client used for dispatching events
 	// to external plugin services.
 	hc *utils.HttpClient
+	// limiter protects the gateway from oversized payloads and webhook storms
+	limiter *rateLimiter
 	// Tracks running handlers for graceful shutdown
 	wg sync.WaitGroup
+	// ordered delivers the events of the same PR or issue one after
+	// another to the plugins which require it
+	ordered *utils.KeyedExecutor
+	// debouncer coalesces the bursts of events for the plugins which require it
+	debouncer *debouncer
+	// registry holds the plugins which registered themselves
+	registry *registry
+	// currentConfig holds the func() config.Config of the framework once it is
+	// ready, it is read by the registration api which may be served before that
+	currentConfig atomic.Value
+	// scheduleState is the state file of the scheduler
+	scheduleState string
 }
 
 func (bot *robot) NewConfig() config.Config {
@@ -43,6 +65,74 @@ func (bot *robot) RegisterEventHandler(f framework.HandlerRegister) {
 	f.RegisterAccessHandler(bot.handleAccessEvent)
 }
 
+// StartEventSource starts the scheduler, whose events are delivered by
+// deliverScheduled rather than emitted to the handlers of webhooks.
+func (bot *robot) StartEventSource(_ func(*framework.GenericEvent), getConfig func() config.Config) {
+	bot.currentConfig.Store(getConfig)
+
+	s := &scheduler{deliver: bot.deliverScheduled, getConfig: getConfig, stateFile: bot.scheduleState}
+	s.start()
+}
+
+// OnConfigChange logs how the routing changed and drops
+// the state kept for the plugins which were removed.
+func (bot *robot) OnConfigChange(old, new config.Config) {
+	oc, _ := old.(*configuration)
+	nc, ok := new.(*configuration)
+	if !ok {
+		return
+	}
+
+	r := diffRoutes(oc, nc)
+	if oc != nil {
+		logrus.WithFields(logrus.Fields{
+			"added-plugins":   r.addedPlugins,
+			"removed-plugins": r.removedPlugins,
+			"changed-plugins": r.changedPlugins,
+			"rerouted-repos":  r.reroutedRepos,
+		}).Info("routing changed")
+	}
+
+	for _, name := range r.removedPlugins {
+		bot.registry.deregister(name)
+		bot.debouncer.drop(name)
+	}
+}
+
+// Shutdown delivers the events which are held back at once, and waits
+// for all the deliveries. It is called once the handlers have finished.
+func (bot *robot) Shutdown() {
+	// the deferred events may be debounced, so they go first
+	if n := bot.limiter.flush(); n > 0 {
+		logrus.WithField("events", n).Info("delivering the deferred events on shutdown")
+	}
+
+	if n := bot.debouncer.flush(); n > 0 {
+		logrus.WithField("events", n).Info("delivering the debounced events on shutdown")
+	}
+
+	bot.wg.Wait()
+}
+
+// registryHandler returns the handler of the registration api of plugins,
+// secret returns the registration secret of each plugin.
+func (bot *robot) registryHandler(secret func(name string) []byte) http.Handler {
+	return &registryHandler{
+		r:      bot.registry,
+		secret: secret,
+		getConfig: func() *configuration {
+			getConfig, ok := bot.currentConfig.Load().(func() config.Config)
+			if !ok {
+				return nil
+			}
+
+			c, _ := bot.getConfig(getConfig())
+
+			return c
+		},
+	}
+}
+
 type ResJson struct {
 	Code    int                     `json:"code"`
 	Message string                  `json:"message"`
@@ -56,8 +146,28 @@ func (bot *robot) handleRequest(w http.ResponseWriter, r *http.Request) *framewo
 			logrus.Warn("when webhook body close, error occurred:", err)
 		}
 	}(r.Body)
-	body, err := io.ReadAll(r.Body)
+
+	if !bot.limiter.allowSource(r) {
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		logrus.WithField("remote-addr", r.RemoteAddr).Warn("webhook rejected, the source is over its rate limit")
+		return nil
+	}
+
+	if r.ContentLength > bot.limiter.maxBodySize {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		logrus.WithField("content-length", r.ContentLength).Warn("webhook rejected, the payload is too large")
+		return nil
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, bot.limiter.maxBodySize))
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+			logrus.Warn("webhook rejected, the payload is too large")
+			return nil
+		}
+
 		logrus.Error("when webhook body to be read, error occurred:", err)
 		return nil
 	}
@@ -86,6 +196,12 @@ func (bot *robot) handleRequest(w http.ResponseWriter, r *http.Request) *framewo
 	}
 
 	ge := resBody.Event
+	if !bot.limiter.allowRepo(ge.Org, ge.Repo) {
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		logrus.WithFields(ge.CollectLogFiled()).Warn("webhook rejected, the org or repo is over its rate limit")
+		return nil
+	}
+
 	ge.SourcePayload = body
 	return ge
 }
@@ -95,39 +211,113 @@ func (bot *robot) handleAccessEvent(evt *framework.GenericEvent, cnf config.Conf
 	if !ok {
 		return fmt.Errorf("can't convert to configuration")
 	}
+	c = bot.registry.resolve(c)
+
+	plugins := c.GetPlugins(evt.Org, evt.Repo, evt.EventName)
+	if len(plugins) == 0 {
+		return nil
+	}
 
-	endpoints := c.GetEndpoints(evt.Org, evt.Repo, evt.EventName)
-	bot.dispatchToDownstreamRobot(endpoints, lgr, evt)
+	if d := bot.limiter.delay(evt.Org, evt.Repo); d > 0 {
+		parked := bot.limiter.park(evt.Org, evt.Repo, d, func() {
+			bot.dispatchToDownstreamRobot(plugins, lgr, evt)
+		})
+		if !parked {
+			return fmt.Errorf("deferred queue is full, event dropped")
+		}
+
+		lgr.WithField("delay", d.String()).Info("event is over the rate limit, its delivery is deferred")
+
+		return nil
+	}
+
+	bot.dispatchToDownstreamRobot(plugins, lgr, evt)
 	return nil
 }
 
-func (bot *robot) dispatchToDownstreamRobot(endpoints []string, lgr *logrus.Entry, evt *framework.GenericEvent) {
+// deliverScheduled delivers the synthetic event of a schedule to the plugins which
+// own the schedule. The rate limiter is skipped, it protects the gateway from the
+// webhook storms of the platforms, while the schedules are paced by the config.
+func (bot *robot) deliverScheduled(evt *framework.GenericEvent, c *configuration) {
+	plugins := bot.registry.resolve(c).GetScheduledPlugins(evt.Org, evt.Repo, evt.Action)
+	if len(plugins) == 0 {
+		return
+	}
 
-	newReq := func(endpoint string) (*http.Request, error) {
-		payload, err := evt.ConvertToBytes()
-		if err != nil {
-			return nil, err
-		}
-		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(payload))
-		if err != nil {
-			return nil, err
-		}
+	bot.dispatchToDownstreamRobot(plugins, logrus.WithFields(evt.CollectLogFiled()), evt)
+}
+
+func (bot *robot) dispatchToDownstreamRobot(plugins []pluginConfig, lgr *logrus.Entry, evt *framework.GenericEvent) {
+	for i := range plugins {
+		p := &plugins[i]
 
-		req.Header.Set("token", "111")
-		return req, nil
-	}
-
-	reqSize := len(endpoints)
-	for i := 0; i < reqSize; i++ {
-		if r, err := newReq(endpoints[i]); err == nil {
-			bot.wg.Add(1)
-			go func(req *http.Request) {
-				_, _ = bot.hc.DoSend(req)
-				bot.wg.Done()
-				fmt.Println("================>")
-			}(r)
-		} else {
-			lgr.WithField("endpoint", endpoints[i]).Error("Error generating http request.", err)
+		if !p.Debounce.applies(evt.EventName) {
+			bot.deliver(p, evt, lgr)
+
+			continue
 		}
+
+		bot.debouncer.submit(
+			debounceKey{plugin: p.Name, key: p.Debounce.coalesceKey(evt)},
+			p.Debounce.window, evt, lgr,
+			func(e *framework.GenericEvent, l *logrus.Entry) {
+				bot.deliver(p, e, l)
+			},
+		)
+	}
+}
+
+func (bot *robot) deliver(p *pluginConfig, evt *framework.GenericEvent, lgr *logrus.Entry) {
+	req, err := newDeliveryRequest(p.Endpoint, evt, p.EventFormat)
+	if err != nil {
+		lgr.WithField("endpoint", p.Endpoint).Error("Error generating http request.", err)
+		return
 	}
+
+	bot.wg.Add(1)
+	send := func() {
+		defer bot.wg.Done()
+		bot.send(req, lgr)
+	}
+
+	if key := evt.OrderingKey(); p.Ordered && key != "" {
+		// the name of the plugin is included, so that a slow plugin
+		// doesn't hold the events back from the other ones
+		bot.ordered.Run(p.Name+"|"+key, send)
+	} else {
+		go send()
+	}
+}
+
+// newDeliveryRequest creates the request which delivers evt to the plugin at endpoint,
+// the event is encoded with format, or with gob if it is empty, so that the plugins
+// built with the libs before proto keep working until they opt in to proto.
+func newDeliveryRequest(endpoint string, evt *framework.GenericEvent, format string) (*http.Request, error) {
+	if format == "" {
+		format = framework.EventFormatGob
+	}
+
+	payload, err := evt.Encode(format)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("token", "111")
+
+	return req, nil
+}
+
+func (bot *robot) send(req *http.Request, lgr *logrus.Entry) {
+	resp, err := bot.hc.DoSend(req)
+	if err != nil || resp == nil {
+		lgr.WithField("endpoint", req.URL.String()).Error("Error delivering event.", err)
+		return
+	}
+
+	_ = resp.Body.Close()
 }
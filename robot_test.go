@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"testing"
+
+	"community-robot-lib/framework"
+)
+
+func TestDeliveryRequestFormat(t *testing.T) {
+	evt := &framework.GenericEvent{}
+	evt.Org = "org"
+	evt.Repo = "repo"
+	evt.PRNumber = "1"
+
+	for _, format := range []string{"", framework.EventFormatGob, framework.EventFormatProto} {
+		req, err := newDeliveryRequest("http://localhost/hook", evt, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the plugins built with the libs before proto decode the body by plain gob
+		got := new(framework.GenericEvent)
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(got)
+		isGob := err == nil && got.Org == "org" && got.PRNumber == "1"
+
+		if wantGob := format != framework.EventFormatProto; isGob != wantGob {
+			t.Errorf("format %q: expected gob=%v, got gob=%v (%v)", format, wantGob, isGob, err)
+		}
+
+		got = new(framework.GenericEvent)
+		if err := got.ConvertFromBytes(b); err != nil || got.Repo != "repo" {
+			t.Errorf("format %q: the current lib can't decode the event: %v", format, err)
+		}
+	}
+}
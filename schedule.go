@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"community-robot-lib/config"
+	"community-robot-lib/framework"
+	"community-robot-lib/interrupts"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// scheduleEventName is the event name of the synthetic events,
+	// the schedule name is carried by the action of the event.
+	scheduleEventName = "schedule"
+	schedulePlatform  = "robot-gateway"
+)
+
+type scheduleOptions struct {
+	StateFile string
+}
+
+func (o *scheduleOptions) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.StateFile, "schedule-state-file", "", "Path to the file which records the last run of the scheduler, so that the schedules due while the gateway was down are run once it restarts. If it is empty, the schedules due before the start are not run.")
+}
+
+type scheduleConfig struct {
+	// Name identifies the schedule, it must be unique among all the plugins.
+	Name string `json:"name" required:"true"`
+
+	// Cron is a standard cron expression of 5 fields, such as "0 8 * * 1-5".
+	// Descriptors such as "@daily" are accepted too.
+	Cron string `json:"cron" required:"true"`
+
+	// Repos is either in the form of org/repos or just org. The synthetic event
+	// is emitted once per item, and it is routed by repo_plugins like any other event.
+	// If no repos are specified, every org or org/repo which the plugin is
+	// configured for in repo_plugins is used.
+	Repos []string `json:"repos,omitempty"`
+}
+
+func (s *scheduleConfig) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("missing schedule name")
+	}
+
+	if _, err := cron.ParseStandard(s.Cron); err != nil {
+		return fmt.Errorf("schedule %s: invalid cron: %v", s.Name, err)
+	}
+
+	return nil
+}
+
+// GetScheduledPlugins returns the plugins which own the schedule
+// and are configured for org/repo.
+func (c *configuration) GetScheduledPlugins(org, repo, schedule string) (ans []pluginConfig) {
+	names := sets.NewString(c.pluginNames(org, repo)...)
+
+	for _, p := range c.ConfigItems.Plugins {
+		if !names.Has(p.Name) {
+			continue
+		}
+
+		for i := range p.Schedules {
+			if p.Schedules[i].Name == schedule {
+				ans = append(ans, p)
+
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// scheduleScopes returns the org or org/repo items which the schedule of plugin applies to.
+func (c *configuration) scheduleScopes(plugin string, s *scheduleConfig) []string {
+	if len(s.Repos) > 0 {
+		return s.Repos
+	}
+
+	var scopes []string
+	for scope, names := range c.ConfigItems.RepoPlugins {
+		for _, name := range names {
+			if name == plugin {
+				scopes = append(scopes, scope)
+
+				break
+			}
+		}
+	}
+
+	return scopes
+}
+
+// scheduler emits the synthetic events of the schedules declared in config.
+// It checks the schedules every minute, so the changes of config are applied
+// from the next minute on. A schedule which is due several times since the
+// last run, such as during a restart, is run only once.
+type scheduler struct {
+	deliver   func(*framework.GenericEvent, *configuration)
+	getConfig func() config.Config
+	// stateFile records the last run, it is optional
+	stateFile string
+
+	mut    sync.Mutex
+	parsed map[string]cron.Schedule
+	last   time.Time
+}
+
+func (s *scheduler) start() {
+	s.restore(time.Now())
+
+	interrupts.Tick(func() { s.runAt(time.Now()) }, func() time.Duration {
+		// wake up just after the next minute begins
+		now := time.Now()
+
+		return now.Truncate(time.Minute).Add(time.Minute + time.Second).Sub(now)
+	})
+}
+
+// restore sets the last run to the one recorded in the state file,
+// or to now if there is none, so that the schedules due from then on are run.
+func (s *scheduler) restore(now time.Time) {
+	last := now
+
+	if s.stateFile != "" {
+		v, err := os.ReadFile(s.stateFile)
+		if err == nil {
+			var t time.Time
+			if err = t.UnmarshalText([]byte(strings.TrimSpace(string(v)))); err == nil && t.Before(now) {
+				last = t
+			}
+		}
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.WithError(err).Warning("scheduler: can't read the state file")
+		}
+	}
+
+	s.mut.Lock()
+	s.last = last
+	s.mut.Unlock()
+}
+
+// save records the last run, the file is replaced at once so that
+// a crash can't leave it half written.
+func (s *scheduler) save(last time.Time) error {
+	v, err := last.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.stateFile), filepath.Base(s.stateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(v)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), s.stateFile)
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// runAt runs the schedules which are due since the last run.
+func (s *scheduler) runAt(now time.Time) {
+	s.mut.Lock()
+	last := s.last
+	s.last = now
+	s.mut.Unlock()
+
+	if s.stateFile != "" {
+		if err := s.save(now); err != nil {
+			logrus.WithError(err).Error("scheduler: can't save the state file")
+		}
+	}
+
+	c, ok := s.getConfig().(*configuration)
+	if !ok {
+		logrus.Error("scheduler: can't convert to configuration")
+
+		return
+	}
+
+	for i := range c.ConfigItems.Plugins {
+		p := &c.ConfigItems.Plugins[i]
+
+		for j := range p.Schedules {
+			item := &p.Schedules[j]
+
+			sched, err := s.schedule(item.Cron)
+			if err != nil {
+				logrus.WithError(err).WithField("schedule", item.Name).Error("scheduler: invalid cron")
+
+				continue
+			}
+
+			if next := sched.Next(last); next.After(now) {
+				continue
+			}
+
+			for _, scope := range c.scheduleScopes(p.Name, item) {
+				s.deliver(newScheduleEvent(item.Name, scope, now), c)
+			}
+		}
+	}
+}
+
+func (s *scheduler) schedule(spec string) (cron.Schedule, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if v, ok := s.parsed[spec]; ok {
+		return v, nil
+	}
+
+	v, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.parsed == nil {
+		s.parsed = make(map[string]cron.Schedule)
+	}
+	s.parsed[spec] = v
+
+	return v, nil
+}
+
+func newScheduleEvent(name, scope string, t time.Time) *framework.GenericEvent {
+	org, repo := scope, ""
+	if v := strings.SplitN(scope, "/", 2); len(v) == 2 {
+		org, repo = v[0], v[1]
+	}
+
+	e := &framework.GenericEvent{}
+	e.EventType = framework.OtherEvent
+	e.PlatformName = schedulePlatform
+	e.EventName = scheduleEventName
+	e.EventUUID = fmt.Sprintf("%s-%s-%d", name, scope, t.Unix())
+	e.Action = name
+	e.Org = org
+	e.Repo = repo
+
+	return e
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"community-robot-lib/config"
+	"community-robot-lib/framework"
+)
+
+func newScheduleConfig(cron string) *configuration {
+	return &configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{
+			"org":        {"a"},
+			"org/repo":   {"b"},
+			"other/repo": {"a", "c"},
+		},
+		Plugins: []pluginConfig{
+			{Name: "a", Endpoint: "http://a", Schedules: []scheduleConfig{{Name: "nightly", Cron: cron}}},
+			{Name: "b", Endpoint: "http://b", Schedules: []scheduleConfig{{Name: "weekly", Cron: cron, Repos: []string{"org/x", "y"}}}},
+			{Name: "c", Endpoint: "http://c", Schedules: []scheduleConfig{{Name: "nightly", Cron: cron}}},
+		},
+	}}
+}
+
+// newTestScheduler returns a scheduler whose last run is last,
+// and a func which returns the events delivered since it was last called.
+func newTestScheduler(c *configuration, stateFile string, last time.Time) (*scheduler, func() []string) {
+	var got []string
+
+	s := &scheduler{
+		deliver: func(e *framework.GenericEvent, _ *configuration) {
+			got = append(got, e.Action+" "+e.Org+"/"+e.Repo)
+		},
+		getConfig: func() config.Config { return c },
+		stateFile: stateFile,
+	}
+	s.restore(last)
+
+	return s, func() []string {
+		ans := got
+		got = nil
+		sort.Strings(ans)
+
+		return ans
+	}
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	last := time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC)
+	s, delivered := newTestScheduler(newScheduleConfig("0 * * * *"), "", last)
+
+	// 09:00, 10:00 and 11:00 are missed, each scope is run once
+	s.runAt(last.Add(2*time.Hour + 35*time.Minute))
+
+	want := []string{"nightly org/", "nightly other/repo", "nightly other/repo", "weekly org/x", "weekly y/"}
+	if got := delivered(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	s.runAt(last.Add(2*time.Hour + 36*time.Minute))
+	if got := delivered(); len(got) != 0 {
+		t.Errorf("expected nothing before the next hour, got %v", got)
+	}
+}
+
+func TestSchedulerFirstTick(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 30, 0, time.UTC)
+
+	// due between the start and the first tick
+	s, delivered := newTestScheduler(newScheduleConfig("1 8 * * *"), "", start)
+	s.runAt(start.Add(31 * time.Second))
+
+	if got := delivered(); len(got) != 5 {
+		t.Errorf("expected the schedule due after the start to run on the first tick, got %v", got)
+	}
+
+	// due before the start, there is no state file to catch up from
+	s, delivered = newTestScheduler(newScheduleConfig("0 8 * * *"), "", start)
+	s.runAt(start.Add(31 * time.Second))
+
+	if got := delivered(); len(got) != 0 {
+		t.Errorf("expected the schedule due before the start not to run, got %v", got)
+	}
+}
+
+func TestSchedulerStateFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state")
+	c := newScheduleConfig("0 8 * * *")
+
+	start := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	s, _ := newTestScheduler(c, file, start)
+	s.runAt(start.Add(50 * time.Minute))
+
+	// the gateway is down from 07:50 to 09:00
+	s, delivered := newTestScheduler(c, file, start.Add(2*time.Hour))
+	s.runAt(start.Add(2*time.Hour + time.Minute))
+
+	if got := delivered(); len(got) != 5 {
+		t.Errorf("expected the schedule due during the restart to run once, got %v", got)
+	}
+
+	s, delivered = newTestScheduler(c, file, start.Add(2*time.Hour+2*time.Minute))
+	s.runAt(start.Add(2*time.Hour + 3*time.Minute))
+
+	if got := delivered(); len(got) != 0 {
+		t.Errorf("expected the schedule not to run twice, got %v", got)
+	}
+
+	if err := os.WriteFile(file, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, delivered = newTestScheduler(c, file, start.Add(2*time.Hour))
+	s.runAt(start.Add(2*time.Hour + time.Minute))
+
+	if got := delivered(); len(got) != 0 {
+		t.Errorf("expected a broken state file to count as none, got %v", got)
+	}
+}
+
+func TestScheduleScopes(t *testing.T) {
+	c := newScheduleConfig("@daily")
+
+	got := c.scheduleScopes("a", &c.ConfigItems.Plugins[0].Schedules[0])
+	sort.Strings(got)
+
+	if want := []string{"org", "other/repo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the scopes of repo_plugins %v, got %v", want, got)
+	}
+
+	got = c.scheduleScopes("b", &c.ConfigItems.Plugins[1].Schedules[0])
+	if want := []string{"org/x", "y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the repos of the schedule %v, got %v", want, got)
+	}
+}
+
+func TestGetScheduledPlugins(t *testing.T) {
+	c := newScheduleConfig("@daily")
+
+	cases := []struct {
+		org, repo, schedule string
+		want                []string
+	}{
+		{"org", "repo", "nightly", []string{"a"}},
+		{"org", "repo", "weekly", []string{"b"}},
+		{"other", "repo", "nightly", []string{"a", "c"}},
+		{"other", "repo", "weekly", nil},
+		{"org", "repo", "unknown", nil},
+		{"none", "", "nightly", nil},
+	}
+
+	for _, tc := range cases {
+		var got []string
+		for _, p := range c.GetScheduledPlugins(tc.org, tc.repo, tc.schedule) {
+			got = append(got, p.Name)
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s/%s %s: expected %v, got %v", tc.org, tc.repo, tc.schedule, tc.want, got)
+		}
+	}
+}
+
+func TestDeliverScheduledSkipsLimiter(t *testing.T) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+	}))
+	defer srv.Close()
+
+	c := &configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"org/repo": {"a"}},
+		Plugins: []pluginConfig{
+			{Name: "a", Endpoint: srv.URL, Schedules: []scheduleConfig{{Name: "nightly", Cron: "@daily"}}},
+		},
+	}}
+
+	limit := limitOptions{MaxBodySize: 1 << 20, RepoRate: 0.001, RepoBurst: 1, Mode: limitModeDefer, DeferredQueueSize: 10}
+	bot := newRobot(&limit, &registryOptions{Lease: time.Minute}, &scheduleOptions{})
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		bot.deliverScheduled(newScheduleEvent("nightly", "org/repo", now.Add(time.Duration(i)*time.Minute)), c)
+	}
+	bot.wg.Wait()
+
+	if v := atomic.LoadInt32(&n); v != 3 {
+		t.Errorf("expected 3 events to be delivered at once, got %d", v)
+	}
+
+	if v := tokens(bot.limiter.repo, "org/repo"); v != 1 {
+		t.Errorf("expected the scheduled events not to take the tokens of the repo, got %v left", v)
+	}
+}